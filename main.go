@@ -55,6 +55,11 @@ func main() {
 				Name:  "force-send",
 				Usage: "if true, will ignore pre-send checks",
 			},
+			&cli.StringFlag{
+				Name:    utils.CarFlag,
+				EnvVars: []string{"FILECOIN_UTILS_CAR"},
+				Usage:   "read chain and state from a CAR snapshot instead of a Lotus node",
+			},
 			cliutil.FlagVeryVerbose,
 		},
 		After: func(c *cli.Context) error {
@@ -79,8 +84,10 @@ var utilsCmd = &cli.Command{
 	Usage: "The extension interface to the filecoin browser project.",
 	Subcommands: []*cli.Command{
 		utils.ExAddressTransformationCmd,
+		utils.AddrCmd,
 		utils.ChainExCmd,
 		utils.MinerExCmd,
 		utils.PowerExCmd,
+		utils.MsigExCmd,
 	},
 }
\ No newline at end of file
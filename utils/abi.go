@@ -0,0 +1,791 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/xerrors"
+)
+
+// ABIArg is a decoded Solidity value. Integers are rendered as decimal
+// strings, addresses and byte strings as 0x hex and tuples as nested ABIArgs.
+type ABIArg struct {
+	Name    string
+	Type    string
+	Value   interface{}
+	Indexed bool `json:",omitempty"`
+}
+
+// ABICall is a decoded contract call, the outputs of a call or an EVM log.
+type ABICall struct {
+	Name      string
+	Signature string
+	Args      []ABIArg
+}
+
+// ABIs is the registry used to decode FEVM traffic. It knows the common
+// ERC-20, ERC-721 and ERC-1155 signatures and is extended with --abi-dir.
+var ABIs = NewABIRegistry()
+
+func init() {
+	if err := ABIs.addHumanReadable(nil, builtinABISignatures); err != nil {
+		panic(err)
+	}
+}
+
+// builtinABISignatures are the ERC-20, ERC-721 and ERC-1155 interfaces.
+// Where the standards share a selector the ERC-20 naming wins.
+var builtinABISignatures = []string{
+	// ERC-20
+	"function name() returns (string)",
+	"function symbol() returns (string)",
+	"function decimals() returns (uint8)",
+	"function totalSupply() returns (uint256)",
+	"function balanceOf(address owner) returns (uint256)",
+	"function allowance(address owner, address spender) returns (uint256)",
+	"function transfer(address to, uint256 value) returns (bool)",
+	"function transferFrom(address from, address to, uint256 value) returns (bool)",
+	"function approve(address spender, uint256 value) returns (bool)",
+	"event Transfer(address indexed from, address indexed to, uint256 value)",
+	"event Approval(address indexed owner, address indexed spender, uint256 value)",
+
+	// ERC-721
+	"function ownerOf(uint256 tokenId) returns (address)",
+	"function safeTransferFrom(address from, address to, uint256 tokenId)",
+	"function safeTransferFrom(address from, address to, uint256 tokenId, bytes data)",
+	"function setApprovalForAll(address operator, bool approved)",
+	"function getApproved(uint256 tokenId) returns (address)",
+	"function isApprovedForAll(address owner, address operator) returns (bool)",
+	"function tokenURI(uint256 tokenId) returns (string)",
+	"event Transfer(address indexed from, address indexed to, uint256 indexed tokenId)",
+	"event Approval(address indexed owner, address indexed approved, uint256 indexed tokenId)",
+	"event ApprovalForAll(address indexed owner, address indexed operator, bool approved)",
+
+	// ERC-1155
+	"function balanceOf(address account, uint256 id) returns (uint256)",
+	"function balanceOfBatch(address[] accounts, uint256[] ids) returns (uint256[])",
+	"function safeTransferFrom(address from, address to, uint256 id, uint256 value, bytes data)",
+	"function safeBatchTransferFrom(address from, address to, uint256[] ids, uint256[] values, bytes data)",
+	"function uri(uint256 id) returns (string)",
+	"event TransferSingle(address indexed operator, address indexed from, address indexed to, uint256 id, uint256 value)",
+	"event TransferBatch(address indexed operator, address indexed from, address indexed to, uint256[] ids, uint256[] values)",
+	"event URI(string value, uint256 indexed id)",
+}
+
+// abiEntry is one element of a JSON ABI.
+type abiEntry struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Inputs    []abiParam `json:"inputs"`
+	Outputs   []abiParam `json:"outputs"`
+	Anonymous bool       `json:"anonymous"`
+}
+
+type abiParam struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Indexed    bool       `json:"indexed"`
+	Components []abiParam `json:"components"`
+}
+
+type abiField struct {
+	name    string
+	typ     *abiType
+	indexed bool
+}
+
+type abiType struct {
+	kind   string // uint, int, address, bool, fixedbytes, bytes, string, slice, array, tuple
+	size   int    // bits of (u)int, length of fixedbytes and array
+	elem   *abiType
+	fields []abiField
+}
+
+type abiMethod struct {
+	name    string
+	sig     string
+	inputs  []abiField
+	outputs []abiField
+}
+
+type contractABI struct {
+	methods map[[4]byte]*abiMethod
+	events  map[ethtypes.EthHash][]*abiMethod
+}
+
+func newContractABI() *contractABI {
+	return &contractABI{
+		methods: make(map[[4]byte]*abiMethod),
+		events:  make(map[ethtypes.EthHash][]*abiMethod),
+	}
+}
+
+// ABIRegistry holds contract ABIs keyed by contract eth address, plus common
+// signatures which apply to every contract.
+type ABIRegistry struct {
+	lk        sync.RWMutex
+	contracts map[ethtypes.EthAddress]*contractABI
+	common    *contractABI
+}
+
+func NewABIRegistry() *ABIRegistry {
+	return &ABIRegistry{
+		contracts: make(map[ethtypes.EthAddress]*contractABI),
+		common:    newContractABI(),
+	}
+}
+
+// Add registers a JSON ABI. Both a bare ABI array and an artifact object with
+// an "abi" field are accepted. A nil address adds the ABI to the common set.
+func (r *ABIRegistry) Add(addr *ethtypes.EthAddress, data []byte) error {
+	var entries []abiEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var artifact struct {
+			ABI []abiEntry `json:"abi"`
+		}
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			return xerrors.Errorf("parsing abi: %w", err)
+		}
+		entries = artifact.ABI
+	}
+	return r.add(addr, entries)
+}
+
+// LoadDir registers every *.json ABI in dir. Files named after a contract
+// address (0x…, f410…/t410… or f0…) apply to that contract only, all other
+// files to every contract.
+func (r *ABIRegistry) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var addr *ethtypes.EthAddress
+		stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if ea, err := ethtypes.ParseEthAddress(stem); err == nil {
+			addr = &ea
+		} else if fa, err := address.NewFromString(stem); err == nil {
+			ea, err := ethtypes.EthAddressFromFilecoinAddress(fa)
+			if err != nil {
+				return xerrors.Errorf("abi %s: %w", file, err)
+			}
+			addr = &ea
+		}
+
+		if err := r.Add(addr, data); err != nil {
+			return xerrors.Errorf("abi %s: %w", file, err)
+		}
+	}
+	log.Infof("loaded %d ABI files from %s", len(files), dir)
+	return nil
+}
+
+func (r *ABIRegistry) add(addr *ethtypes.EthAddress, entries []abiEntry) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	c := r.common
+	if addr != nil {
+		var ok bool
+		if c, ok = r.contracts[*addr]; !ok {
+			c = newContractABI()
+			r.contracts[*addr] = c
+		}
+	}
+
+	for _, e := range entries {
+		if e.Type != "function" && e.Type != "event" {
+			continue
+		}
+		m, err := newABIMethod(e)
+		if err != nil {
+			return err
+		}
+		hash := keccak256([]byte(m.sig))
+		if e.Type == "function" {
+			var sel [4]byte
+			copy(sel[:], hash)
+			if _, ok := c.methods[sel]; !ok {
+				c.methods[sel] = m
+			}
+		} else if !e.Anonymous {
+			var topic ethtypes.EthHash
+			copy(topic[:], hash)
+			c.events[topic] = append(c.events[topic], m)
+		}
+	}
+	return nil
+}
+
+func (r *ABIRegistry) addHumanReadable(addr *ethtypes.EthAddress, sigs []string) error {
+	entries := make([]abiEntry, 0, len(sigs))
+	for _, sig := range sigs {
+		e, err := parseHumanReadableABI(sig)
+		if err != nil {
+			return xerrors.Errorf("parsing %q: %w", sig, err)
+		}
+		entries = append(entries, e)
+	}
+	return r.add(addr, entries)
+}
+
+// lookup returns the ABIs which apply to a contract reachable at any of addrs,
+// most specific first.
+func (r *ABIRegistry) lookup(addrs []ethtypes.EthAddress) []*contractABI {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+
+	var out []*contractABI
+	for _, a := range addrs {
+		if c, ok := r.contracts[a]; ok {
+			out = append(out, c)
+		}
+	}
+	return append(out, r.common)
+}
+
+func (r *ABIRegistry) method(addrs []ethtypes.EthAddress, calldata []byte) *abiMethod {
+	if len(calldata) < 4 {
+		return nil
+	}
+	var sel [4]byte
+	copy(sel[:], calldata)
+	for _, c := range r.lookup(addrs) {
+		if m, ok := c.methods[sel]; ok {
+			return m
+		}
+	}
+	return nil
+}
+
+// DecodeCall decodes calldata sent to the contract reachable at addrs.
+func (r *ABIRegistry) DecodeCall(addrs []ethtypes.EthAddress, calldata []byte) (*ABICall, error) {
+	m := r.method(addrs, calldata)
+	if m == nil {
+		return nil, xerrors.Errorf("unknown function selector")
+	}
+	args, err := decodeABIFields(calldata[4:], m.inputs)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding %s inputs: %w", m.sig, err)
+	}
+	return &ABICall{Name: m.name, Signature: m.sig, Args: args}, nil
+}
+
+// DecodeReturn decodes the data returned by the call made with calldata.
+func (r *ABIRegistry) DecodeReturn(addrs []ethtypes.EthAddress, calldata, ret []byte) (*ABICall, error) {
+	m := r.method(addrs, calldata)
+	if m == nil {
+		return nil, xerrors.Errorf("unknown function selector")
+	}
+	args, err := decodeABIFields(ret, m.outputs)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding %s outputs: %w", m.sig, err)
+	}
+	return &ABICall{Name: m.name, Signature: m.sig, Args: args}, nil
+}
+
+// DecodeLog decodes an EVM log emitted by the contract reachable at addrs.
+func (r *ABIRegistry) DecodeLog(addrs []ethtypes.EthAddress, topics [][]byte, data []byte) (*ABICall, error) {
+	if len(topics) == 0 {
+		return nil, xerrors.Errorf("anonymous event")
+	}
+	var topic0 ethtypes.EthHash
+	if len(topics[0]) != len(topic0) {
+		return nil, xerrors.Errorf("invalid topic length %d", len(topics[0]))
+	}
+	copy(topic0[:], topics[0])
+
+	// selectors can collide, e.g. across user loaded ABIs, so every
+	// candidate is tried before giving up
+	var firstErr error
+	for _, c := range r.lookup(addrs) {
+		for _, ev := range c.events[topic0] {
+			call, err := decodeABIEvent(ev, topics, data)
+			if err == nil {
+				return call, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, xerrors.Errorf("unknown event topic %s", topic0)
+}
+
+// decodeABIEvent decodes a log against the event ev its topic0 matches.
+func decodeABIEvent(ev *abiMethod, topics [][]byte, data []byte) (*ABICall, error) {
+	var indexed, unindexed []abiField
+	for _, f := range ev.inputs {
+		if f.indexed {
+			indexed = append(indexed, f)
+		} else {
+			unindexed = append(unindexed, f)
+		}
+	}
+	// the same signature can differ in which params are indexed, e.g. ERC-20
+	// and ERC-721 Transfer
+	if len(indexed) != len(topics)-1 {
+		return nil, xerrors.Errorf("%s has %d indexed params, log has %d topics", ev.sig, len(indexed), len(topics)-1)
+	}
+
+	idx := make([]ABIArg, len(indexed))
+	for i, f := range indexed {
+		idx[i] = ABIArg{Name: f.name, Type: f.typ.String(), Indexed: true}
+		if f.typ.isDynamic() || f.typ.kind == "tuple" || f.typ.kind == "array" {
+			// only the hash of reference types is logged
+			idx[i].Value = "0x" + hex.EncodeToString(topics[i+1])
+			continue
+		}
+		v, err := decodeABIValue(topics[i+1], 0, f.typ)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding %s topic %d: %w", ev.sig, i+1, err)
+		}
+		idx[i].Value = v
+	}
+	rest, err := decodeABIFields(data, unindexed)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding %s data: %w", ev.sig, err)
+	}
+
+	// keep the declaration order of the params
+	args := make([]ABIArg, 0, len(ev.inputs))
+	for _, f := range ev.inputs {
+		if f.indexed {
+			args, idx = append(args, idx[0]), idx[1:]
+		} else {
+			args, rest = append(args, rest[0]), rest[1:]
+		}
+	}
+	return &ABICall{Name: ev.name, Signature: ev.sig, Args: args}, nil
+}
+
+func newABIMethod(e abiEntry) (*abiMethod, error) {
+	inputs, err := newABIFields(e.Inputs)
+	if err != nil {
+		return nil, xerrors.Errorf("%s inputs: %w", e.Name, err)
+	}
+	outputs, err := newABIFields(e.Outputs)
+	if err != nil {
+		return nil, xerrors.Errorf("%s outputs: %w", e.Name, err)
+	}
+
+	names := make([]string, len(inputs))
+	for i, f := range inputs {
+		names[i] = f.typ.String()
+	}
+	return &abiMethod{
+		name:    e.Name,
+		sig:     e.Name + "(" + strings.Join(names, ",") + ")",
+		inputs:  inputs,
+		outputs: outputs,
+	}, nil
+}
+
+func newABIFields(params []abiParam) ([]abiField, error) {
+	fields := make([]abiField, len(params))
+	for i, p := range params {
+		t, err := parseABIType(p.Type, p.Components)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = abiField{name: p.Name, typ: t, indexed: p.Indexed}
+	}
+	return fields, nil
+}
+
+func parseABIType(s string, components []abiParam) (*abiType, error) {
+	if strings.HasSuffix(s, "]") {
+		i := strings.LastIndex(s, "[")
+		if i < 0 {
+			return nil, xerrors.Errorf("invalid type %q", s)
+		}
+		elem, err := parseABIType(s[:i], components)
+		if err != nil {
+			return nil, err
+		}
+		dim := s[i+1 : len(s)-1]
+		if dim == "" {
+			return &abiType{kind: "slice", elem: elem}, nil
+		}
+		n, err := strconv.Atoi(dim)
+		if err != nil || n <= 0 {
+			return nil, xerrors.Errorf("invalid array length in %q", s)
+		}
+		return &abiType{kind: "array", size: n, elem: elem}, nil
+	}
+
+	switch {
+	case s == "tuple":
+		fields, err := newABIFields(components)
+		if err != nil {
+			return nil, err
+		}
+		return &abiType{kind: "tuple", fields: fields}, nil
+	case s == "address", s == "bool", s == "string", s == "bytes":
+		return &abiType{kind: s}, nil
+	case s == "function":
+		return &abiType{kind: "fixedbytes", size: 24}, nil
+	case strings.HasPrefix(s, "uint"), strings.HasPrefix(s, "int"):
+		kind := "int"
+		if strings.HasPrefix(s, "uint") {
+			kind = "uint"
+		}
+		bits := 256
+		if rest := strings.TrimPrefix(s, kind); rest != "" {
+			n, err := strconv.Atoi(rest)
+			if err != nil || n <= 0 || n > 256 || n%8 != 0 {
+				return nil, xerrors.Errorf("invalid type %q", s)
+			}
+			bits = n
+		}
+		return &abiType{kind: kind, size: bits}, nil
+	case strings.HasPrefix(s, "bytes"):
+		n, err := strconv.Atoi(strings.TrimPrefix(s, "bytes"))
+		if err != nil || n <= 0 || n > 32 {
+			return nil, xerrors.Errorf("invalid type %q", s)
+		}
+		return &abiType{kind: "fixedbytes", size: n}, nil
+	}
+	return nil, xerrors.Errorf("unsupported type %q", s)
+}
+
+// String returns the canonical type name used in signatures.
+func (t *abiType) String() string {
+	switch t.kind {
+	case "uint", "int":
+		return t.kind + strconv.Itoa(t.size)
+	case "fixedbytes":
+		return "bytes" + strconv.Itoa(t.size)
+	case "slice":
+		return t.elem.String() + "[]"
+	case "array":
+		return t.elem.String() + "[" + strconv.Itoa(t.size) + "]"
+	case "tuple":
+		names := make([]string, len(t.fields))
+		for i, f := range t.fields {
+			names[i] = f.typ.String()
+		}
+		return "(" + strings.Join(names, ",") + ")"
+	}
+	return t.kind
+}
+
+func (t *abiType) isDynamic() bool {
+	switch t.kind {
+	case "bytes", "string", "slice":
+		return true
+	case "array":
+		return t.elem.isDynamic()
+	case "tuple":
+		for _, f := range t.fields {
+			if f.typ.isDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize is the number of bytes a value takes in the head of its enclosing
+// tuple.
+func (t *abiType) headSize() int {
+	if t.isDynamic() {
+		return 32
+	}
+	switch t.kind {
+	case "array":
+		return t.size * t.elem.headSize()
+	case "tuple":
+		n := 0
+		for _, f := range t.fields {
+			n += f.typ.headSize()
+		}
+		return n
+	}
+	return 32
+}
+
+func abiWord(data []byte, off int) ([]byte, error) {
+	if off < 0 || off+32 > len(data) {
+		return nil, xerrors.Errorf("offset %d out of bounds (%d bytes)", off, len(data))
+	}
+	return data[off : off+32], nil
+}
+
+// abiInt reads a word as an offset or length and checks it fits in data.
+func abiInt(data []byte, off int) (int, error) {
+	w, err := abiWord(data, off)
+	if err != nil {
+		return 0, err
+	}
+	v := new(big.Int).SetBytes(w)
+	if !v.IsInt64() || v.Int64() > int64(len(data)) {
+		return 0, xerrors.Errorf("value %s at offset %d out of bounds", v, off)
+	}
+	return int(v.Int64()), nil
+}
+
+func decodeABIFields(data []byte, fields []abiField) ([]ABIArg, error) {
+	vals, err := decodeABITuple(data, fieldTypes(fields))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]ABIArg, len(fields))
+	for i, f := range fields {
+		args[i] = ABIArg{Name: f.name, Type: f.typ.String(), Value: vals[i]}
+	}
+	return args, nil
+}
+
+func fieldTypes(fields []abiField) []*abiType {
+	ts := make([]*abiType, len(fields))
+	for i, f := range fields {
+		ts[i] = f.typ
+	}
+	return ts
+}
+
+// decodeABITuple decodes consecutive values laid out head/tail at the start of
+// data.
+func decodeABITuple(data []byte, ts []*abiType) ([]interface{}, error) {
+	out := make([]interface{}, len(ts))
+	head := 0
+	for i, t := range ts {
+		if t.isDynamic() {
+			off, err := abiInt(data, head)
+			if err != nil {
+				return nil, err
+			}
+			if out[i], err = decodeABIValue(data, off, t); err != nil {
+				return nil, err
+			}
+		} else {
+			v, err := decodeABIValue(data, head, t)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		head += t.headSize()
+	}
+	return out, nil
+}
+
+func decodeABIValue(data []byte, off int, t *abiType) (interface{}, error) {
+	switch t.kind {
+	case "uint", "int":
+		w, err := abiWord(data, off)
+		if err != nil {
+			return nil, err
+		}
+		v := new(big.Int).SetBytes(w)
+		if t.kind == "int" && w[0]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		return v.String(), nil
+	case "address":
+		w, err := abiWord(data, off)
+		if err != nil {
+			return nil, err
+		}
+		ea, err := ethtypes.CastEthAddress(w[12:])
+		if err != nil {
+			return nil, err
+		}
+		return ea.String(), nil
+	case "bool":
+		w, err := abiWord(data, off)
+		if err != nil {
+			return nil, err
+		}
+		return w[31] != 0, nil
+	case "fixedbytes":
+		w, err := abiWord(data, off)
+		if err != nil {
+			return nil, err
+		}
+		return "0x" + hex.EncodeToString(w[:t.size]), nil
+	case "bytes", "string":
+		n, err := abiInt(data, off)
+		if err != nil {
+			return nil, err
+		}
+		start := off + 32
+		if start+n > len(data) {
+			return nil, xerrors.Errorf("%s of length %d at offset %d out of bounds", t.kind, n, off)
+		}
+		b := data[start : start+n]
+		if t.kind == "string" && utf8.Valid(b) {
+			return string(b), nil
+		}
+		return "0x" + hex.EncodeToString(b), nil
+	case "slice":
+		n, err := abiInt(data, off)
+		if err != nil {
+			return nil, err
+		}
+		if n*t.elem.headSize() > len(data)-off-32 {
+			return nil, xerrors.Errorf("array of length %d at offset %d out of bounds", n, off)
+		}
+		return decodeABIList(data[off+32:], t.elem, n)
+	case "array":
+		if off > len(data) {
+			return nil, xerrors.Errorf("offset %d out of bounds", off)
+		}
+		return decodeABIList(data[off:], t.elem, t.size)
+	case "tuple":
+		if off > len(data) {
+			return nil, xerrors.Errorf("offset %d out of bounds", off)
+		}
+		return decodeABIFields(data[off:], t.fields)
+	}
+	return nil, xerrors.Errorf("unsupported type %s", t)
+}
+
+func decodeABIList(data []byte, elem *abiType, n int) ([]interface{}, error) {
+	ts := make([]*abiType, n)
+	for i := range ts {
+		ts[i] = elem
+	}
+	return decodeABITuple(data, ts)
+}
+
+// parseHumanReadableABI parses a signature such as
+// "event Transfer(address indexed from, address indexed to, uint256 value)".
+// Tuples are not supported.
+func parseHumanReadableABI(sig string) (abiEntry, error) {
+	kind, rest, ok := strings.Cut(strings.TrimSpace(sig), " ")
+	if !ok || (kind != "function" && kind != "event") {
+		return abiEntry{}, xerrors.Errorf("expected function or event")
+	}
+	open := strings.Index(rest, "(")
+	closing := strings.Index(rest, ")")
+	if open < 0 || closing < open {
+		return abiEntry{}, xerrors.Errorf("missing parameter list")
+	}
+
+	e := abiEntry{Type: kind, Name: strings.TrimSpace(rest[:open])}
+	var err error
+	if e.Inputs, err = parseHumanReadableParams(rest[open+1 : closing]); err != nil {
+		return abiEntry{}, err
+	}
+
+	if ret := strings.TrimSpace(rest[closing+1:]); ret != "" {
+		ret = strings.TrimSpace(strings.TrimPrefix(ret, "returns"))
+		if !strings.HasPrefix(ret, "(") || !strings.HasSuffix(ret, ")") {
+			return abiEntry{}, xerrors.Errorf("invalid returns clause")
+		}
+		if e.Outputs, err = parseHumanReadableParams(ret[1 : len(ret)-1]); err != nil {
+			return abiEntry{}, err
+		}
+	}
+	return e, nil
+}
+
+func parseHumanReadableParams(s string) ([]abiParam, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var params []abiParam
+	for _, p := range strings.Split(s, ",") {
+		words := strings.Fields(p)
+		if len(words) == 0 {
+			return nil, xerrors.Errorf("empty parameter")
+		}
+		param := abiParam{Type: words[0]}
+		for _, w := range words[1:] {
+			if w == "indexed" {
+				param.Indexed = true
+			} else {
+				param.Name = w
+			}
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+func keccak256(b []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// ethAddressesOf lists the eth addresses a contract may be registered under:
+// its f410 address when it has one, and its masked ID address.
+func ethAddressesOf(addr address.Address, act *types.Actor) []ethtypes.EthAddress {
+	var out []ethtypes.EthAddress
+	if addr.Protocol() == address.Delegated {
+		if ea, err := ethtypes.EthAddressFromFilecoinAddress(addr); err == nil {
+			out = append(out, ea)
+		}
+	}
+	if act != nil && act.DelegatedAddress != nil {
+		if ea, err := ethtypes.EthAddressFromFilecoinAddress(*act.DelegatedAddress); err == nil {
+			out = append(out, ea)
+		}
+	}
+	if addr.Protocol() == address.ID {
+		if id, err := address.IDFromAddress(addr); err == nil {
+			out = append(out, ethtypes.EthAddressFromActorID(abi.ActorID(id)))
+		}
+	}
+	return out
+}
+
+// unwrapCborBytes returns the payload of a CBOR byte string, which is how
+// InvokeContract params and returns are carried. Anything else is returned as
+// is.
+func unwrapCborBytes(b []byte) []byte {
+	var cb abi.CborBytes
+	if err := cb.UnmarshalCBOR(bytes.NewReader(b)); err != nil {
+		return b
+	}
+	return cb
+}
+
+// DecodeInvokeContractParams renders InvokeContract params as a decoded ABI
+// call, or as hex when the function is unknown.
+func DecodeInvokeContractParams(to address.Address, act *types.Actor, params []byte) string {
+	calldata := unwrapCborBytes(params)
+	if call, err := ABIs.DecodeCall(ethAddressesOf(to, act), calldata); err == nil {
+		if b, err := json.Marshal(call); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("{\"params\":\"0x%s\"}", hex.EncodeToString(params))
+}
+
+// DecodeInvokeContractReturn renders the return of an InvokeContract call as
+// decoded ABI outputs, or as hex when the function is unknown.
+func DecodeInvokeContractReturn(to address.Address, act *types.Actor, params, ret []byte) string {
+	calldata := unwrapCborBytes(params)
+	if call, err := ABIs.DecodeReturn(ethAddressesOf(to, act), calldata, unwrapCborBytes(ret)); err == nil {
+		if b, err := json.Marshal(call); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("{\"return\":\"0x%s\"}", hex.EncodeToString(ret))
+}
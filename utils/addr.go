@@ -0,0 +1,239 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+var AddrCmd = &cli.Command{
+	Name:  "addr",
+	Usage: "Inspect and convert addresses",
+	Subcommands: []*cli.Command{
+		AddrConvertCmd,
+		AddrRolesCmd,
+	},
+}
+
+// EXAddressConversion is everything that can be told about an address without
+// chain state. NeedsNode lists the fields of EXAddressDescription only a node
+// can resolve: the ID and robust Filecoin forms, the Eth address of key
+// addresses or the one an ID may have been assigned, and the actor Type.
+type EXAddressConversion struct {
+	Input    string
+	Protocol string
+	// network of the input's prefix, mainnet (f) or testnet (t)
+	Network string `json:",omitempty"`
+	// checksum of the input: valid, invalid or none for ID addresses and
+	// single case 0x addresses, which carry none
+	Checksum   string
+	Payload    string
+	ID         *uint64 `json:",omitempty"`
+	Namespace  *uint64 `json:",omitempty"`
+	SubAddress string  `json:",omitempty"`
+	Mainnet    string
+	Testnet    string
+	// 0x form: the contract address of f410 addresses, the masked ID
+	// (0xff00...) of ID addresses
+	Eth         string `json:",omitempty"`
+	EthChecksum string `json:",omitempty"`
+	NeedsNode   []string
+}
+
+var AddrConvertCmd = &cli.Command{
+	Name:      "convert",
+	Usage:     "Convert an address between its forms offline, without a Lotus node",
+	ArgsUsage: "address(f0/f1/f2/f3/f4/t.../0x)",
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Args().Present() {
+			return xerrors.Errorf("must pass the address(id/fil/eth)")
+		}
+
+		out, err := ConvertAddress(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		afmt := NewAppFmt(cctx.App)
+		afmt.Println(string(b))
+		return nil
+	},
+}
+
+// ConvertAddress performs every state independent conversion of s.
+func ConvertAddress(s string) (*EXAddressConversion, error) {
+	out := &EXAddressConversion{Input: s}
+
+	out.Checksum = "valid"
+	addr, eaddr, err := parseAddress(s)
+	if err == address.ErrInvalidChecksum {
+		out.Checksum = "invalid"
+		addr, err = decodeUnchecked(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if eaddr != nil {
+		digits := s
+		if strings.HasPrefix(strings.ToLower(s), "0x") {
+			digits = s[2:]
+		}
+		out.Checksum = eip55Status(digits, *eaddr)
+	} else {
+		switch s[:1] {
+		case address.MainnetPrefix:
+			out.Network = "mainnet"
+		case address.TestnetPrefix:
+			out.Network = "testnet"
+		}
+		if addr.Protocol() == address.ID {
+			out.Checksum = "none"
+		}
+	}
+
+	out.Payload = "0x" + hex.EncodeToString(addr.Payload())
+	str := addr.String()
+	out.Mainnet = address.MainnetPrefix + str[1:]
+	out.Testnet = address.TestnetPrefix + str[1:]
+
+	switch addr.Protocol() {
+	case address.ID:
+		out.Protocol = "id"
+		id, err := address.IDFromAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		out.ID = &id
+		out.NeedsNode = []string{"Filecoin", "Eth", "Type"}
+	case address.SECP256K1:
+		out.Protocol = "secp256k1"
+		out.NeedsNode = []string{"ID", "Eth", "Type"}
+	case address.Actor:
+		out.Protocol = "actor"
+		out.NeedsNode = []string{"ID", "Eth", "Type"}
+	case address.BLS:
+		out.Protocol = "bls"
+		out.NeedsNode = []string{"ID", "Eth", "Type"}
+	case address.Delegated:
+		out.Protocol = "delegated"
+		ns, n := binary.Uvarint(addr.Payload())
+		if n <= 0 {
+			return nil, xerrors.Errorf("invalid delegated address namespace")
+		}
+		out.Namespace = &ns
+		out.SubAddress = "0x" + hex.EncodeToString(addr.Payload()[n:])
+		out.NeedsNode = []string{"ID", "Type"}
+	default:
+		return nil, xerrors.Errorf("unknown address protocol %d", addr.Protocol())
+	}
+
+	// only ID addresses and f410 addresses have an Ethereum form
+	if addr.Protocol() == address.ID || (out.Namespace != nil && *out.Namespace == builtin.EthereumAddressManagerActorID) {
+		eaddr, err := ethtypes.EthAddressFromFilecoinAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		out.Eth = eaddr.String()
+		out.EthChecksum = eip55(eaddr)
+	}
+
+	return out, nil
+}
+
+// parseAddress parses an id/fil/eth address. Eth addresses, 0x prefixed in
+// either case or bare 40 digit hex, are returned with their Filecoin form;
+// eth is nil for Filecoin addresses.
+func parseAddress(s string) (addr address.Address, eth *ethtypes.EthAddress, err error) {
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		return parseEthAddress(s)
+	}
+	addr, err = address.NewFromString(s)
+	if err != nil && len(s) == 2*ethtypes.EthAddressLength {
+		// bare hex eth address
+		if faddr, eaddr, eerr := parseEthAddress(s); eerr == nil {
+			return faddr, eaddr, nil
+		}
+	}
+	return addr, nil, err
+}
+
+func parseEthAddress(s string) (address.Address, *ethtypes.EthAddress, error) {
+	eaddr, err := ethtypes.ParseEthAddress(s)
+	if err != nil {
+		return address.Undef, nil, err
+	}
+	addr, err := eaddr.ToFilecoinAddress()
+	if err != nil {
+		return address.Undef, nil, err
+	}
+	return addr, &eaddr, nil
+}
+
+// decodeUnchecked decodes an f1/f2/f3/f4 address string whose checksum
+// doesn't match, address.NewFromString having validated everything else.
+func decodeUnchecked(s string) (address.Address, error) {
+	protocol := s[1] - '0'
+	raw := s[2:]
+
+	var prefix []byte
+	if protocol == address.Delegated {
+		parts := strings.SplitN(raw, "f", 2)
+		ns, err := strconv.ParseUint(parts[0], 10, 63)
+		if err != nil {
+			return address.Undef, err
+		}
+		prefix = binary.AppendUvarint(nil, ns)
+		raw = parts[1]
+	}
+	decoded, err := address.AddressEncoding.WithPadding(-1).DecodeString(raw)
+	if err != nil {
+		return address.Undef, err
+	}
+	payload := append(prefix, decoded[:len(decoded)-address.ChecksumHashLength]...)
+	return address.NewFromBytes(append([]byte{protocol}, payload...))
+}
+
+// eip55 returns the mixed case checksum encoding of addr (EIP-55).
+func eip55(addr ethtypes.EthAddress) string {
+	lower := hex.EncodeToString(addr[:])
+	hash := keccak256([]byte(lower))
+	out := []byte(lower)
+	for i, c := range out {
+		if c < 'a' {
+			continue
+		}
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0xf >= 8 {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(out)
+}
+
+// eip55Status checks the hex digits of a 0x address against its EIP-55
+// checksum. Single case input carries no checksum.
+func eip55Status(digits string, addr ethtypes.EthAddress) string {
+	if digits == strings.ToLower(digits) || digits == strings.ToUpper(digits) {
+		return "none"
+	}
+	if "0x"+digits != eip55(addr) {
+		return "invalid"
+	}
+	return "valid"
+}
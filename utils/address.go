@@ -1,17 +1,31 @@
 package utils
 
 import (
+	"bufio"
 	"context"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
-	
-	"github.com/urfave/cli/v2"
+	"io"
+	"os"
+	"path"
+	"strings"
+
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/manifest"
 	"github.com/filecoin-project/lotus/api/v0api"
 	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/evm"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
-
 )
 
 type EXAddressDescription struct {
@@ -19,15 +33,100 @@ type EXAddressDescription struct {
 	Filecoin address.Address
 	Eth      ethtypes.EthAddress
 	Type     string
+	// height of the tipset the address was resolved at
+	StateHeight abi.ChainEpoch
+	// height of the first tipset whose state has the actor, see AddressFirstSeen
+	FirstSeen *abi.ChainEpoch `json:",omitempty"`
+	Roles     *EXAddressRoles `json:",omitempty"`
+}
+
+// EXAddressRoles describes what an address is used for, depending on the type
+// of its actor. Miners lists the miners an account, multisig or EVM actor
+// holds a role in, from the cached miner role index, and is only filled with
+// --miner-roles.
+type EXAddressRoles struct {
+	// multisig
+	Signers   []address.Address `json:",omitempty"`
+	Threshold uint64            `json:",omitempty"`
+	// miner
+	Owner            *address.Address  `json:",omitempty"`
+	Worker           *address.Address  `json:",omitempty"`
+	ControlAddresses []address.Address `json:",omitempty"`
+	Beneficiary      *address.Address  `json:",omitempty"`
+	// evm
+	BytecodeHash string  `json:",omitempty"`
+	Nonce        *uint64 `json:",omitempty"`
+
+	Miners []EXMinerRole `json:",omitempty"`
+	Note   string        `json:",omitempty"`
+}
+
+// EXAddressRecord is one line of a batch description. Error is set instead of
+// the description when the input could not be resolved.
+type EXAddressRecord struct {
+	Input string
+	*EXAddressDescription
+	Error string `json:",omitempty"`
+}
+
+func (r *EXAddressRecord) header() []string {
+	return []string{"input", "id", "filecoin", "eth", "type", "state_height", "first_seen", "roles", "error"}
+}
+
+func (r *EXAddressRecord) row() []string {
+	if r.EXAddressDescription == nil {
+		return []string{r.Input, "", "", "", "", "", "", "", r.Error}
+	}
+	firstSeen := ""
+	if r.FirstSeen != nil {
+		firstSeen = r.FirstSeen.String()
+	}
+	roles := ""
+	if r.Roles != nil {
+		// nested, so kept as JSON
+		b, _ := json.Marshal(r.Roles)
+		roles = string(b)
+	}
+	return []string{r.Input, r.ID, r.Filecoin.String(), r.Eth.String(), r.Type, r.StateHeight.String(), firstSeen, roles, r.Error}
 }
 
+// number of addresses read and resolved before a batch is written out
+const addrBatchSize = 1024
 
 var ExAddressTransformationCmd = &cli.Command{
 	Name:      "addr-description",
 	Aliases:   []string{"addrdescription"},
 	Usage:     "Get ID Fil Eth address from id/fil/eth address",
-	ArgsUsage: "address",
+	ArgsUsage: "address | --batch [file]",
+	Flags: append(append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "batch",
+			Usage: "describe the addresses read one per line from file, or stdin when no file or - is given",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "batch output format: ndjson or csv",
+			Value: "ndjson",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of batch addresses resolved in parallel",
+			Value: 16,
+		},
+		&cli.BoolFlag{
+			Name:  "first-seen",
+			Usage: "find the height the actor first appears at by bisecting historical state",
+		},
+		&cli.BoolFlag{
+			Name:  "miner-roles",
+			Usage: "list the miners an account, multisig or EVM address holds roles in, from the cached miner role index (scans every miner when no index is cached)",
+		},
+	}, addrTipSetFlags...), minerRoleCacheFlags...),
 	Action: func(cctx *cli.Context) error {
+		if cctx.Bool("batch") {
+			return describeAddressBatch(cctx)
+		}
+
 		if argc := cctx.Args().Len(); argc < 1 {
 			return xerrors.Errorf("must pass the address(id/fil/eth)")
 		}
@@ -39,81 +138,396 @@ var ExAddressTransformationCmd = &cli.Command{
 		defer closer()
 		ctx := ReqContext(cctx)
 
-		addrString := cctx.Args().Get(0)
-
-		var out EXAddressDescription
+		ts, err := loadAddrTipSet(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
 
-		var faddr address.Address
-		var eaddr ethtypes.EthAddress
-		addr, err := address.NewFromString(addrString)
-		if err != nil { // This isn't a filecoin address
-			eaddr, err = ethtypes.ParseEthAddress(addrString)
-			if err != nil { // This isn't an Eth address either
-				return xerrors.Errorf("address is not a filecoin or eth address")
-			}
-			faddr, err = eaddr.ToFilecoinAddress()
-			if err != nil {
+		out, err := DescribeAddress(ctx, api, ts, cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		if cctx.Bool("first-seen") {
+			if out.FirstSeen, err = AddressFirstSeen(ctx, api, out.Filecoin, ts); err != nil {
 				return err
 			}
-		} else {
-			eaddr, faddr, err = ethAddrFromFilecoinAddress(ctx, addr, api)
+		}
+		if cctx.Bool("miner-roles") && minerRoleHolder(out) {
+			idx, _, err := loadMinerRoleIndexFlags(ctx, cctx, api, ts)
 			if err != nil {
 				return err
 			}
+			addMinerRoles(out, idx)
 		}
 
-		newfaddr, err := api.StateAccountKey(ctx, faddr, types.EmptyTSK)
-		if err == nil {
-			faddr = newfaddr
+		byte, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
 		}
+		afmt := NewAppFmt(cctx.App)
+		afmt.Println(string(byte))
+		return nil
+	},
+}
 
-		out.Filecoin = faddr
-		out.Eth = eaddr
+var addrTipSetFlags = []cli.Flag{
+	&cli.Int64Flag{
+		Name:  "height",
+		Usage: "resolve the address at the tipset of this height (default: chain head)",
+		Value: -1,
+	},
+	&cli.StringFlag{
+		Name:  "tipset",
+		Usage: "resolve the address at this tipset, given as comma separated block cids or @height",
+	},
+}
 
-		actor, err := api.StateGetActor(ctx, faddr, types.EmptyTSK)
-		if err == nil {
-			id, err := api.StateLookupID(ctx, faddr, types.EmptyTSK)
-			if err != nil {
-				out.ID = "n/a"
-			} else {
-				out.ID = id.String()
-			}
-			if name, _, ok := actors.GetActorMetaByCode(actor.Code); ok {
-				out.Type = name
-			} else {
-				out.Type = "unknown"
-			}
+// loadAddrTipSet returns the tipset selected by --height or --tipset, or the
+// chain head. A null round height selects the last tipset before it.
+func loadAddrTipSet(ctx context.Context, cctx *cli.Context, api v0api.FullNode) (*types.TipSet, error) {
+	if h := cctx.Int64("height"); h >= 0 {
+		if cctx.IsSet("tipset") {
+			return nil, xerrors.Errorf("--height and --tipset are mutually exclusive")
+		}
+		return api.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(h), types.EmptyTSK)
+	}
+	return lcli.LoadTipSet(ctx, cctx, api)
+}
+
+// DescribeAddress resolves an id/fil/eth address at ts to its ID, robust and
+// eth forms and the type of its actor.
+func DescribeAddress(ctx context.Context, api v0api.FullNode, ts *types.TipSet, addrString string) (*EXAddressDescription, error) {
+	out := EXAddressDescription{StateHeight: ts.Height()}
+	tsk := ts.Key()
+
+	faddr, ethAddr, err := parseAddress(addrString)
+	if err != nil {
+		return nil, xerrors.Errorf("address is not a filecoin or eth address")
+	}
+	var eaddr ethtypes.EthAddress
+	if ethAddr != nil {
+		eaddr = *ethAddr
+	} else {
+		eaddr, faddr, err = ethAddrFromFilecoinAddress(ctx, faddr, api, tsk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newfaddr, err := api.StateAccountKey(ctx, faddr, tsk)
+	if err == nil {
+		faddr = newfaddr
+	}
+
+	out.Filecoin = faddr
+	out.Eth = eaddr
+
+	actor, err := api.StateGetActor(ctx, faddr, tsk)
+	if err == nil {
+		id, err := api.StateLookupID(ctx, faddr, tsk)
+		if err != nil {
+			out.ID = "n/a"
+		} else {
+			out.ID = id.String()
+		}
+		if name, _, ok := actors.GetActorMetaByCode(actor.Code); ok {
+			out.Type = name
 		} else {
-			out.ID = "unknown"
 			out.Type = "unknown"
 		}
+		if out.Roles, err = describeRoles(ctx, api, actor); err != nil {
+			return nil, err
+		}
+	} else {
+		out.ID = "unknown"
+		out.Type = "unknown"
+	}
 
-		byte, err := json.MarshalIndent(out, "", "  ")
+	return &out, nil
+}
+
+// describeRoles reads the role details of actor, or returns nil for actors
+// without any.
+func describeRoles(ctx context.Context, api v0api.FullNode, actor *types.Actor) (*EXAddressRoles, error) {
+	switch {
+	case builtin.IsMultisigActor(actor.Code):
+		st, err := multisig.Load(apiActorStore(ctx, api), actor)
+		if err != nil {
+			return nil, err
+		}
+		out := new(EXAddressRoles)
+		if out.Signers, err = st.Signers(); err != nil {
+			return nil, err
+		}
+		if out.Threshold, err = st.Threshold(); err != nil {
+			return nil, err
+		}
+		return out, nil
+	case builtin.IsStorageMinerActor(actor.Code):
+		st, err := miner.Load(apiActorStore(ctx, api), actor)
+		if err != nil {
+			return nil, err
+		}
+		info, err := st.Info()
+		if err != nil {
+			return nil, err
+		}
+		return &EXAddressRoles{
+			Owner:            &info.Owner,
+			Worker:           &info.Worker,
+			ControlAddresses: info.ControlAddresses,
+			Beneficiary:      &info.Beneficiary,
+		}, nil
+	case builtin.IsEvmActor(actor.Code):
+		st, err := evm.Load(apiActorStore(ctx, api), actor)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := st.GetBytecodeHash()
+		if err != nil {
+			return nil, err
+		}
+		nonce, err := st.Nonce()
+		if err != nil {
+			return nil, err
+		}
+		return &EXAddressRoles{BytecodeHash: "0x" + hex.EncodeToString(hash[:]), Nonce: &nonce}, nil
+	case builtin.IsPlaceholderActor(actor.Code):
+		return &EXAddressRoles{
+			Note: "placeholder awaiting deployment",
+		}, nil
+	}
+	return nil, nil
+}
+
+// minerRoleHolder reports whether the described address is of an actor type
+// whose miner roles are listed: accounts, multisigs and EVM actors.
+func minerRoleHolder(desc *EXAddressDescription) bool {
+	switch path.Base(desc.Type) {
+	case manifest.AccountKey, manifest.EthAccountKey, manifest.MultisigKey, manifest.EvmKey:
+		return true
+	}
+	return false
+}
+
+// addMinerRoles adds the miners the described address holds roles in.
+func addMinerRoles(desc *EXAddressDescription, idx MinerRoleIndex) {
+	if !minerRoleHolder(desc) {
+		return
+	}
+	id, err := address.NewFromString(desc.ID)
+	if err != nil {
+		// not on chain
+		return
+	}
+	miners := idx[id]
+	if len(miners) == 0 {
+		return
+	}
+	if desc.Roles == nil {
+		desc.Roles = new(EXAddressRoles)
+	}
+	desc.Roles.Miners = miners
+}
+
+// AddressFirstSeen bisects the chain up to ts for the first tipset whose state
+// has the actor of addr, which was created by a message of the tipset before.
+// It returns nil when the actor doesn't exist at ts. Actors are assumed to
+// exist from their creation on, so a deleted actor is only found while it
+// still exists at ts. Historical state needs an archival node.
+func AddressFirstSeen(ctx context.Context, api v0api.FullNode, addr address.Address, ts *types.TipSet) (*abi.ChainEpoch, error) {
+	// ID addresses are never reused, unlike the resolution of robust ones
+	id, err := api.StateLookupID(ctx, addr, ts.Key())
+	if err != nil {
+		if isActorNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// looking up an ID address doesn't check the actor exists
+	if _, err := api.StateGetActor(ctx, id, ts.Key()); err != nil {
+		if isActorNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	exists := func(h abi.ChainEpoch) (*types.TipSet, bool, error) {
+		hts, err := api.ChainGetTipSetByHeight(ctx, h, ts.Key())
+		if err != nil {
+			return nil, false, xerrors.Errorf("get tipset at height %d: %w", h, err)
+		}
+		if _, err := api.StateGetActor(ctx, id, hts.Key()); err != nil {
+			if isActorNotFound(err) {
+				return hts, false, nil
+			}
+			return nil, false, xerrors.Errorf("get actor at height %d: %w", hts.Height(), err)
+		}
+		return hts, true, nil
+	}
+
+	// invariant: the actor is missing below lo and exists at hi
+	lo, hi := abi.ChainEpoch(0), ts.Height()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		hts, ok, err := exists(mid)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			// a null round resolves to an earlier tipset
+			hi = hts.Height()
+		} else {
+			lo = mid + 1
+		}
+	}
+	return &hi, nil
+}
+
+func isActorNotFound(err error) bool {
+	return strings.Contains(err.Error(), types.ErrActorNotFound.Error())
+}
+
+// describeAddressBatch describes the addresses of a file or stdin over one API
+// session. Records are written in input order; an address that fails to
+// resolve produces a record carrying the error rather than aborting.
+func describeAddressBatch(cctx *cli.Context) error {
+	afmt := NewAppFmt(cctx.App)
+
+	format := cctx.String("format")
+	if format != "ndjson" && format != "csv" {
+		return xerrors.Errorf("unknown format %q", format)
+	}
+	concurrency := cctx.Int("concurrency")
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var in io.Reader = afmt.Stdin
+	if path := cctx.Args().First(); path != "" && path != "-" {
+		f, err := os.Open(path)
 		if err != nil {
 			return err
 		}
-		afmt := NewAppFmt(cctx.App)
-		afmt.Println(string(byte))
+		defer f.Close() //nolint:errcheck
+		in = f
+	}
+
+	api, closer, err := GetFullNodeAPI(cctx)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	ctx := ReqContext(cctx)
+
+	// every address is resolved at the same tipset
+	ts, err := loadAddrTipSet(ctx, cctx, api)
+	if err != nil {
+		return err
+	}
+	firstSeen := cctx.Bool("first-seen")
+	var idx MinerRoleIndex
+	if cctx.Bool("miner-roles") {
+		// built once for the whole batch
+		if idx, _, err = loadMinerRoleIndexFlags(ctx, cctx, api, ts); err != nil {
+			return err
+		}
+	}
+
+	var w *csv.Writer
+	if format == "csv" {
+		w = csv.NewWriter(cctx.App.Writer)
+		if err := w.Write((*EXAddressRecord)(nil).header()); err != nil {
+			return err
+		}
+	}
+
+	flush := func(batch []string) error {
+		records := make([]*EXAddressRecord, len(batch))
+		var eg errgroup.Group
+		eg.SetLimit(concurrency)
+		for i, s := range batch {
+			i, s := i, s
+			eg.Go(func() error {
+				rec := &EXAddressRecord{Input: s}
+				desc, err := DescribeAddress(ctx, api, ts, s)
+				if err == nil && firstSeen {
+					desc.FirstSeen, err = AddressFirstSeen(ctx, api, desc.Filecoin, ts)
+				}
+				if err != nil {
+					rec.Error = err.Error()
+				} else {
+					if idx != nil {
+						addMinerRoles(desc, idx)
+					}
+					rec.EXAddressDescription = desc
+				}
+				records[i] = rec
+				return nil
+			})
+		}
+		_ = eg.Wait()
+
+		for _, rec := range records {
+			if w != nil {
+				if err := w.Write(rec.row()); err != nil {
+					return err
+				}
+				continue
+			}
+			b, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			afmt.Println(string(b))
+		}
+		if w != nil {
+			w.Flush()
+			return w.Error()
+		}
 		return nil
-	},
+	}
+
+	var batch []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		batch = append(batch, line)
+		if len(batch) == addrBatchSize {
+			if err := flush(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return flush(batch)
+	}
+	return nil
 }
 
-func ethAddrFromFilecoinAddress(ctx context.Context, addr address.Address, fnapi v0api.FullNode) (ethtypes.EthAddress, address.Address, error) {
+func ethAddrFromFilecoinAddress(ctx context.Context, addr address.Address, fnapi v0api.FullNode, tsk types.TipSetKey) (ethtypes.EthAddress, address.Address, error) {
 	var faddr address.Address
 	var err error
 
 	switch addr.Protocol() {
 	case address.BLS, address.SECP256K1:
-		faddr, err = fnapi.StateLookupID(ctx, addr, types.EmptyTSK)
+		faddr, err = fnapi.StateLookupID(ctx, addr, tsk)
 		if err != nil {
 			return ethtypes.EthAddress{}, addr, err
 		}
 	case address.Actor, address.ID:
-		faddr, err = fnapi.StateLookupID(ctx, addr, types.EmptyTSK)
+		faddr, err = fnapi.StateLookupID(ctx, addr, tsk)
 		if err != nil {
 			return ethtypes.EthAddress{}, addr, err
 		}
-		fAct, err := fnapi.StateGetActor(ctx, faddr, types.EmptyTSK)
+		fAct, err := fnapi.StateGetActor(ctx, faddr, tsk)
 		if err != nil {
 			return ethtypes.EthAddress{}, addr, err
 		}
@@ -132,4 +546,4 @@ func ethAddrFromFilecoinAddress(ctx context.Context, addr address.Address, fnapi
 	}
 
 	return ethAddr, faddr, nil
-}
\ No newline at end of file
+}
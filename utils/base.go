@@ -7,6 +7,9 @@ import (
 
 	logging "github.com/ipfs/go-log/v2"
 
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/api/v1api"
 	cliutil "github.com/filecoin-project/lotus/cli/util"
 	ufcli "github.com/urfave/cli/v2"
 )
@@ -21,13 +24,41 @@ var GetAPI = cliutil.GetCommonAPI
 var DaemonContext = cliutil.DaemonContext
 var ReqContext = cliutil.ReqContext
 
-var GetFullNodeAPI = cliutil.GetFullNodeAPI
-var GetFullNodeAPIV1 = cliutil.GetFullNodeAPIV1
 var GetGatewayAPI = cliutil.GetGatewayAPI
 
 var GetStorageMinerAPI = cliutil.GetStorageMinerAPI
 var GetWorkerAPI = cliutil.GetWorkerAPI
 
+// CarFlag is the global flag selecting a CAR snapshot to read chain and state
+// from instead of dialing a Lotus node.
+const CarFlag = "car"
+
+// GetFullNodeAPI returns the v0 full node API, backed by the CAR snapshot
+// when --car is set.
+func GetFullNodeAPI(ctx *ufcli.Context) (v0api.FullNode, jsonrpc.ClientCloser, error) {
+	if path := ctx.String(CarFlag); path != "" {
+		cc, closer, err := openCarChain(ctx.Context, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &carFullNode{cc: cc}, closer, nil
+	}
+	return cliutil.GetFullNodeAPI(ctx)
+}
+
+// GetFullNodeAPIV1 returns the v1 full node API, backed by the CAR snapshot
+// when --car is set.
+func GetFullNodeAPIV1(ctx *ufcli.Context) (v1api.FullNode, jsonrpc.ClientCloser, error) {
+	if path := ctx.String(CarFlag); path != "" {
+		cc, closer, err := openCarChain(ctx.Context, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &carFullNodeV1{cc: cc}, closer, nil
+	}
+	return cliutil.GetFullNodeAPIV1(ctx)
+}
+
 type AppFmt struct {
 	app   *ufcli.App
 	Stdin io.Reader
@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/build/buildconstants"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+// default number of epochs observed before --to when --from is not given
+const defaultBaseFeeWindow = 120
+
+// EXBaseFeeEpoch is the base fee of a tipset's messages and the gas it
+// packed. GasLimit sums the gas limits of the tipset's distinct messages,
+// which is what the base fee update rule charges against; FillRate is that
+// sum per block over the block gas limit, 0.5 being the target.
+type EXBaseFeeEpoch struct {
+	Height        abi.ChainEpoch
+	Blocks        int
+	ParentBaseFee abi.TokenAmount
+	GasLimit      int64
+	FillRate      float64
+	// base fee of the next non-null tipset's messages
+	NextBaseFee abi.TokenAmount
+}
+
+// EXBaseFeeScenario is the projected base fee of the epochs after the range
+// if every block is filled to FillRate of the block gas limit. The first
+// projection follows from the last observed tipset and is the same in every
+// scenario. Null rounds are not modelled.
+type EXBaseFeeScenario struct {
+	Name     string
+	FillRate float64
+	BaseFees []abi.TokenAmount
+}
+
+// EXBaseFeeForecast projects the base fee of the Epochs following FromHeight.
+type EXBaseFeeForecast struct {
+	FromHeight abi.ChainEpoch
+	Epochs     int
+	Scenarios  []*EXBaseFeeScenario
+}
+
+func (e *EXBaseFeeEpoch) header() []string {
+	return []string{"height", "blocks", "parent_base_fee", "gas_limit", "fill_rate", "next_base_fee"}
+}
+
+func (e *EXBaseFeeEpoch) row() []string {
+	return []string{
+		e.Height.String(), strconv.Itoa(e.Blocks), e.ParentBaseFee.String(), strconv.FormatInt(e.GasLimit, 10),
+		strconv.FormatFloat(e.FillRate, 'f', 4, 64), e.NextBaseFee.String(),
+	}
+}
+
+// baseFeeEpoch reads the gas packed in ts the way the chain computes the
+// next base fee: distinct messages across the tipset's blocks, regardless of
+// whether the VM applies them.
+func baseFeeEpoch(ctx context.Context, api v0api.FullNode, ts *types.TipSet) (*EXBaseFeeEpoch, error) {
+	seen := make(map[cid.Cid]struct{})
+	var gasLimit int64
+	for _, blk := range ts.Blocks() {
+		msgs, err := api.ChainGetBlockMessages(ctx, blk.Cid())
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get messages: %w", err)
+		}
+		for _, m := range msgs.BlsMessages {
+			if _, ok := seen[m.Cid()]; !ok {
+				seen[m.Cid()] = struct{}{}
+				gasLimit += m.GasLimit
+			}
+		}
+		for _, m := range msgs.SecpkMessages {
+			if _, ok := seen[m.Cid()]; !ok {
+				seen[m.Cid()] = struct{}{}
+				gasLimit += m.Message.GasLimit
+			}
+		}
+	}
+
+	baseFee := ts.Blocks()[0].ParentBaseFee
+	return &EXBaseFeeEpoch{
+		Height:        ts.Height(),
+		Blocks:        len(ts.Blocks()),
+		ParentBaseFee: baseFee,
+		GasLimit:      gasLimit,
+		FillRate:      float64(gasLimit) / float64(int64(len(ts.Blocks()))*buildconstants.BlockGasLimit),
+		NextBaseFee:   store.ComputeNextBaseFee(baseFee, gasLimit, len(ts.Blocks()), ts.Height()),
+	}, nil
+}
+
+// forecastBaseFee projects n epochs after last, under the observed mean fill
+// rate of history and each of fills. history must not be empty.
+func forecastBaseFee(last *EXBaseFeeEpoch, history []*EXBaseFeeEpoch, n int, fills []float64) (*EXBaseFeeForecast, error) {
+	if len(history) == 0 {
+		return nil, xerrors.Errorf("no observed tipsets to forecast from")
+	}
+	var observed float64
+	for _, e := range history {
+		observed += e.FillRate
+	}
+	observed /= float64(len(history))
+
+	scenarios := []*EXBaseFeeScenario{{Name: "observed", FillRate: observed}}
+	for _, f := range fills {
+		scenarios = append(scenarios, &EXBaseFeeScenario{Name: "fill-" + strconv.FormatFloat(f, 'f', -1, 64), FillRate: f})
+	}
+
+	for _, s := range scenarios {
+		perBlock := int64(s.FillRate * float64(buildconstants.BlockGasLimit))
+		baseFee := last.NextBaseFee
+		s.BaseFees = make([]abi.TokenAmount, 0, n)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				baseFee = store.ComputeNextBaseFee(baseFee, perBlock, 1, last.Height+abi.ChainEpoch(i))
+			}
+			s.BaseFees = append(s.BaseFees, baseFee)
+		}
+	}
+
+	return &EXBaseFeeForecast{
+		FromHeight: last.Height + 1,
+		Epochs:     n,
+		Scenarios:  scenarios,
+	}, nil
+}
+
+var ChainBaseFeeCmd = &cli.Command{
+	Name:  "basefee",
+	Usage: "Print the base fee history of a height range and optionally project it forward",
+	Description: `Prints the base fee of every non-null tipset in the range with the gas it
+packed. With --forecast N, the base fee of the N epochs after the range is
+projected with the protocol's update rule, starting from the base fee that
+follows the last tipset of the range, for the mean fill rate observed over the
+range and for each --fill scenario.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "from",
+			Usage: "first height of the range (default: 120 epochs before --to)",
+			Value: -1,
+		},
+		&cli.Int64Flag{
+			Name:  "to",
+			Usage: "last height of the range (default: chain head)",
+			Value: -1,
+		},
+		&cli.IntFlag{
+			Name:  "forecast",
+			Usage: "number of epochs to project after the range",
+		},
+		&cli.Float64SliceFlag{
+			Name:  "fill",
+			Usage: "block fill rates, as fractions of the block gas limit, to project the base fee for",
+			Value: cli.NewFloat64Slice(0, 0.25, 0.5, 0.75, 1),
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format: json or csv",
+			Value: "json",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		format := cctx.String("format")
+		if format != "json" && format != "csv" {
+			return xerrors.Errorf("unknown format %q", format)
+		}
+		n := cctx.Int("forecast")
+		if n < 0 {
+			return xerrors.Errorf("--forecast must not be negative")
+		}
+		fills := cctx.Float64Slice("fill")
+		for _, f := range fills {
+			if f < 0 || f > 1 {
+				return xerrors.Errorf("fill rate %v is not between 0 and 1", f)
+			}
+		}
+
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		to := head.Height()
+		if cctx.Int64("to") >= 0 && abi.ChainEpoch(cctx.Int64("to")) < to {
+			to = abi.ChainEpoch(cctx.Int64("to"))
+		}
+		from := to - defaultBaseFeeWindow + 1
+		if cctx.Int64("from") >= 0 {
+			from = abi.ChainEpoch(cctx.Int64("from"))
+		}
+		if from < 0 {
+			from = 0
+		}
+		if from > to {
+			return xerrors.Errorf("--from %d is after --to %d", from, to)
+		}
+
+		var history []*EXBaseFeeEpoch
+		for h := from; h <= to; h++ {
+			ts, err := api.ChainGetTipSetByHeight(ctx, h, head.Key())
+			if err != nil {
+				return xerrors.Errorf("get tipset at height %d: %w", h, err)
+			}
+			if ts.Height() != h {
+				// null round
+				continue
+			}
+			e, err := baseFeeEpoch(ctx, api, ts)
+			if err != nil {
+				return xerrors.Errorf("height %d: %w", h, err)
+			}
+			history = append(history, e)
+		}
+		if len(history) == 0 {
+			// only null rounds, nothing to report or forecast from
+			return xerrors.Errorf("no tipsets between heights %d and %d", from, to)
+		}
+
+		var forecast *EXBaseFeeForecast
+		if n > 0 {
+			if forecast, err = forecastBaseFee(history[len(history)-1], history, n, fills); err != nil {
+				return err
+			}
+		}
+
+		if format == "csv" {
+			w := csv.NewWriter(cctx.App.Writer)
+			if forecast != nil {
+				hdr := []string{"height"}
+				for _, s := range forecast.Scenarios {
+					hdr = append(hdr, s.Name)
+				}
+				if err := w.Write(hdr); err != nil {
+					return err
+				}
+				for i := 0; i < n; i++ {
+					row := []string{(forecast.FromHeight + abi.ChainEpoch(i)).String()}
+					for _, s := range forecast.Scenarios {
+						row = append(row, s.BaseFees[i].String())
+					}
+					if err := w.Write(row); err != nil {
+						return err
+					}
+				}
+			} else {
+				if err := w.Write((*EXBaseFeeEpoch)(nil).header()); err != nil {
+					return err
+				}
+				for _, e := range history {
+					if err := w.Write(e.row()); err != nil {
+						return err
+					}
+				}
+			}
+			w.Flush()
+			return w.Error()
+		}
+
+		b, err := json.MarshalIndent(struct {
+			History  []*EXBaseFeeEpoch
+			Forecast *EXBaseFeeForecast `json:",omitempty"`
+		}{history, forecast}, "", "  ")
+		if err != nil {
+			return err
+		}
+		afmt.Println(string(b))
+		return nil
+	},
+}
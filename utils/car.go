@@ -0,0 +1,510 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	amt4 "github.com/filecoin-project/go-amt-ipld/v4"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/account"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/power"
+	"github.com/filecoin-project/lotus/chain/consensus/filcns"
+	"github.com/filecoin-project/lotus/chain/state"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	carbs "github.com/ipld/go-car/v2/blockstore"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// carChain serves chain and state reads from a CAR snapshot. The snapshot
+// roots are taken as the chain head.
+type carChain struct {
+	path string
+	refs int
+
+	car   *carbs.ReadOnly
+	bs    blockstore.Blockstore
+	cs    *store.ChainStore
+	head  *types.TipSet
+	store cbor.IpldStore
+}
+
+var (
+	carChainsLk sync.Mutex
+	carChains   = map[string]*carChain{}
+)
+
+// openCarChain opens (or reuses) the snapshot at path. The CAR index is built
+// once per process, so commands which dial both the v0 and v1 APIs only pay
+// for it once.
+func openCarChain(ctx context.Context, path string) (*carChain, jsonrpc.ClientCloser, error) {
+	carChainsLk.Lock()
+	defer carChainsLk.Unlock()
+
+	cc, ok := carChains[path]
+	if !ok {
+		log.Infof("loading CAR snapshot %s", path)
+
+		car, err := carbs.OpenReadOnly(path)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("opening CAR snapshot: %w", err)
+		}
+		roots, err := car.Roots()
+		if err != nil {
+			_ = car.Close()
+			return nil, nil, xerrors.Errorf("reading CAR roots: %w", err)
+		}
+
+		bs := blockstore.Adapt(car)
+		cs := store.NewChainStore(bs, bs, dssync.MutexWrap(datastore.NewMapDatastore()), filcns.Weight, nil)
+
+		head, err := cs.LoadTipSet(ctx, types.NewTipSetKey(roots...))
+		if err != nil {
+			_ = cs.Close()
+			_ = car.Close()
+			return nil, nil, xerrors.Errorf("loading snapshot head: %w", err)
+		}
+
+		cc = &carChain{
+			path:  path,
+			car:   car,
+			bs:    bs,
+			cs:    cs,
+			head:  head,
+			store: cs.ActorStore(ctx),
+		}
+		carChains[path] = cc
+	}
+	cc.refs++
+
+	return cc, cc.release, nil
+}
+
+func (cc *carChain) release() {
+	carChainsLk.Lock()
+	defer carChainsLk.Unlock()
+
+	cc.refs--
+	if cc.refs > 0 {
+		return
+	}
+	delete(carChains, cc.path)
+	_ = cc.cs.Close()
+	_ = cc.car.Close()
+}
+
+func (cc *carChain) loadTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	if tsk.IsEmpty() {
+		return cc.head, nil
+	}
+	return cc.cs.LoadTipSet(ctx, tsk)
+}
+
+func (cc *carChain) stateTree(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, *state.StateTree, error) {
+	ts, err := cc.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading tipset %s: %w", tsk, err)
+	}
+	st, err := state.LoadStateTree(cc.store, ts.ParentState())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("loading state tree: %w", err)
+	}
+	return ts, st, nil
+}
+
+func (cc *carChain) loadActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	_, st, err := cc.stateTree(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetActor(addr)
+}
+
+func (cc *carChain) loadMiner(ctx context.Context, addr address.Address, tsk types.TipSetKey) (miner.State, *types.Actor, error) {
+	act, err := cc.loadActor(ctx, addr, tsk)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to load miner actor: %w", err)
+	}
+	mas, err := miner.Load(cc.cs.ActorStore(ctx), act)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to load miner actor state: %w", err)
+	}
+	return mas, act, nil
+}
+
+func (cc *carChain) loadPower(ctx context.Context, tsk types.TipSetKey) (power.State, error) {
+	act, err := cc.loadActor(ctx, power.Address, tsk)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load power actor: %w", err)
+	}
+	return power.Load(cc.cs.ActorStore(ctx), act)
+}
+
+// carFullNode implements the subset of the v0 FullNode API used by the utils
+// commands on top of a CAR snapshot. Everything else returns ErrNotSupported.
+type carFullNode struct {
+	v0api.FullNodeStub
+	cc *carChain
+}
+
+var _ v0api.FullNode = (*carFullNode)(nil)
+
+func (n *carFullNode) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return n.cc.head, nil
+}
+
+func (n *carFullNode) ChainGetTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	return n.cc.loadTipSet(ctx, tsk)
+}
+
+func (n *carFullNode) ChainGetTipSetByHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	ts, err := n.cc.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, xerrors.Errorf("loading tipset %s: %w", tsk, err)
+	}
+	return n.cc.cs.GetTipsetByHeight(ctx, h, ts, true)
+}
+
+func (n *carFullNode) ChainGetBlock(ctx context.Context, c cid.Cid) (*types.BlockHeader, error) {
+	return n.cc.cs.GetBlock(ctx, c)
+}
+
+func (n *carFullNode) ChainGetBlockMessages(ctx context.Context, c cid.Cid) (*lapi.BlockMessages, error) {
+	b, err := n.cc.cs.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	bmsgs, smsgs, err := n.cc.cs.MessagesForBlock(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	cids := make([]cid.Cid, len(bmsgs)+len(smsgs))
+	for i, m := range bmsgs {
+		cids[i] = m.Cid()
+	}
+	for i, m := range smsgs {
+		cids[i+len(bmsgs)] = m.Cid()
+	}
+
+	return &lapi.BlockMessages{
+		BlsMessages:   bmsgs,
+		SecpkMessages: smsgs,
+		Cids:          cids,
+	}, nil
+}
+
+func (n *carFullNode) ChainGetParentMessages(ctx context.Context, c cid.Cid) ([]lapi.Message, error) {
+	b, err := n.cc.cs.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// genesis block has no parent messages...
+	if b.Height == 0 {
+		return nil, nil
+	}
+
+	pts, err := n.cc.cs.LoadTipSet(ctx, types.NewTipSetKey(b.Parents...))
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := n.cc.cs.MessagesForTipset(ctx, pts)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []lapi.Message
+	for _, m := range cm {
+		out = append(out, lapi.Message{
+			Cid:     m.Cid(),
+			Message: m.VMMessage(),
+		})
+	}
+	return out, nil
+}
+
+func (n *carFullNode) ChainGetMessagesInTipset(ctx context.Context, tsk types.TipSetKey) ([]lapi.Message, error) {
+	ts, err := n.cc.loadTipSet(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	// genesis has no messages...
+	if ts.Height() == 0 {
+		return nil, nil
+	}
+
+	cm, err := n.cc.cs.MessagesForTipset(ctx, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []lapi.Message
+	for _, m := range cm {
+		out = append(out, lapi.Message{
+			Cid:     m.Cid(),
+			Message: m.VMMessage(),
+		})
+	}
+	return out, nil
+}
+
+func (n *carFullNode) ChainGetParentReceipts(ctx context.Context, c cid.Cid) ([]*types.MessageReceipt, error) {
+	b, err := n.cc.cs.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Height == 0 {
+		return nil, nil
+	}
+
+	receipts, err := n.cc.cs.ReadReceipts(ctx, b.ParentMessageReceipts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*types.MessageReceipt, len(receipts))
+	for i := range receipts {
+		out[i] = &receipts[i]
+	}
+	return out, nil
+}
+
+func (n *carFullNode) ChainGetMessage(ctx context.Context, c cid.Cid) (*types.Message, error) {
+	cm, err := n.cc.cs.GetCMessage(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return cm.VMMessage(), nil
+}
+
+func (n *carFullNode) ChainReadObj(ctx context.Context, c cid.Cid) ([]byte, error) {
+	blk, err := n.cc.bs.Get(ctx, c)
+	if err != nil {
+		return nil, xerrors.Errorf("blockstore get: %w", err)
+	}
+	return blk.RawData(), nil
+}
+
+func (n *carFullNode) ChainHasObj(ctx context.Context, c cid.Cid) (bool, error) {
+	return n.cc.bs.Has(ctx, c)
+}
+
+func (n *carFullNode) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return n.cc.loadActor(ctx, addr, tsk)
+}
+
+func (n *carFullNode) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	_, st, err := n.cc.stateTree(ctx, tsk)
+	if err != nil {
+		return address.Undef, err
+	}
+	return st.LookupIDAddress(addr)
+}
+
+func (n *carFullNode) StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	switch addr.Protocol() {
+	case address.BLS, address.SECP256K1, address.Delegated:
+		return addr, nil
+	}
+
+	act, err := n.cc.loadActor(ctx, addr, tsk)
+	if err != nil {
+		return address.Undef, xerrors.Errorf("failed to find actor: %s", addr)
+	}
+
+	if builtin.IsAccountActor(act.Code) {
+		aast, err := account.Load(n.cc.cs.ActorStore(ctx), act)
+		if err != nil {
+			return address.Undef, xerrors.Errorf("failed to get account actor state for %s: %w", addr, err)
+		}
+		return aast.PubkeyAddress()
+	}
+
+	if act.DelegatedAddress != nil {
+		return *act.DelegatedAddress, nil
+	}
+
+	return address.Undef, xerrors.Errorf("address %s has no deterministic form", addr)
+}
+
+func (n *carFullNode) StateListMiners(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error) {
+	pas, err := n.cc.loadPower(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return pas.ListAllMiners()
+}
+
+func (n *carFullNode) StateMinerPower(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*lapi.MinerPower, error) {
+	pas, err := n.cc.loadPower(ctx, tsk)
+	if err != nil {
+		return nil, err
+	}
+
+	tpow, err := pas.TotalPower()
+	if err != nil {
+		return nil, err
+	}
+
+	mpow, found, err := pas.MinerPower(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return &lapi.MinerPower{TotalPower: tpow}, nil
+	}
+
+	minpow, err := pas.MinerNominalPowerMeetsConsensusMinimum(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lapi.MinerPower{
+		MinerPower:  mpow,
+		TotalPower:  tpow,
+		HasMinPower: minpow,
+	}, nil
+}
+
+func (n *carFullNode) StateMinerSectors(ctx context.Context, addr address.Address, sectorNos *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	mas, _, err := n.cc.loadMiner(ctx, addr, tsk)
+	if err != nil {
+		return nil, err
+	}
+	return mas.LoadSectors(sectorNos)
+}
+
+func (n *carFullNode) StateMinerSectorCount(ctx context.Context, addr address.Address, tsk types.TipSetKey) (lapi.MinerSectors, error) {
+	mas, _, err := n.cc.loadMiner(ctx, addr, tsk)
+	if err != nil {
+		return lapi.MinerSectors{}, err
+	}
+
+	var activeCount, liveCount, faultyCount uint64
+	if err := mas.ForEachDeadline(func(_ uint64, dl miner.Deadline) error {
+		return dl.ForEachPartition(func(_ uint64, part miner.Partition) error {
+			for _, c := range []struct {
+				sectors func() (bitfield.BitField, error)
+				count   *uint64
+			}{
+				{part.ActiveSectors, &activeCount},
+				{part.LiveSectors, &liveCount},
+				{part.FaultySectors, &faultyCount},
+			} {
+				bf, err := c.sectors()
+				if err != nil {
+					return err
+				}
+				cnt, err := bf.Count()
+				if err != nil {
+					return err
+				}
+				*c.count += cnt
+			}
+			return nil
+		})
+	}); err != nil {
+		return lapi.MinerSectors{}, err
+	}
+
+	return lapi.MinerSectors{Live: liveCount, Active: activeCount, Faulty: faultyCount}, nil
+}
+
+func (n *carFullNode) StateMinerRecoveries(ctx context.Context, addr address.Address, tsk types.TipSetKey) (bitfield.BitField, error) {
+	mas, _, err := n.cc.loadMiner(ctx, addr, tsk)
+	if err != nil {
+		return bitfield.BitField{}, err
+	}
+	return miner.AllPartSectors(mas, miner.Partition.RecoveringSectors)
+}
+
+func (n *carFullNode) StateMinerAvailableBalance(ctx context.Context, addr address.Address, tsk types.TipSetKey) (types.BigInt, error) {
+	ts, err := n.cc.loadTipSet(ctx, tsk)
+	if err != nil {
+		return types.EmptyInt, xerrors.Errorf("loading tipset %s: %w", tsk, err)
+	}
+
+	mas, act, err := n.cc.loadMiner(ctx, addr, ts.Key())
+	if err != nil {
+		return types.EmptyInt, err
+	}
+
+	vested, err := mas.VestedFunds(ts.Height())
+	if err != nil {
+		return types.EmptyInt, err
+	}
+
+	abal, err := mas.AvailableBalance(act.Balance)
+	if err != nil {
+		return types.EmptyInt, err
+	}
+
+	return types.BigAdd(abal, vested), nil
+}
+
+func (n *carFullNode) WalletBalance(ctx context.Context, addr address.Address) (types.BigInt, error) {
+	act, err := n.cc.loadActor(ctx, addr, types.EmptyTSK)
+	if xerrors.Is(err, types.ErrActorNotFound) {
+		return types.NewInt(0), nil
+	} else if err != nil {
+		return types.EmptyInt, err
+	}
+	return act.Balance, nil
+}
+
+// carFullNodeV1 exposes the v1-only methods used by the utils commands on top
+// of the same snapshot.
+type carFullNodeV1 struct {
+	lapi.FullNodeStub
+	cc *carChain
+}
+
+var _ v1api.FullNode = (*carFullNodeV1)(nil)
+
+func (n *carFullNodeV1) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return n.cc.head, nil
+}
+
+func (n *carFullNodeV1) ChainGetEvents(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+	evtArr, err := amt4.LoadAMT(ctx, n.cc.store, root, amt4.UseTreeBitWidth(types.EventAMTBitwidth))
+	if err != nil {
+		return nil, xerrors.Errorf("load events amt: %w", err)
+	}
+
+	ret := make([]types.Event, 0, evtArr.Len())
+	var evt types.Event
+	err = evtArr.ForEach(ctx, func(u uint64, deferred *cbg.Deferred) error {
+		if u > math.MaxInt {
+			return xerrors.Errorf("too many events")
+		}
+		if err := evt.UnmarshalCBOR(bytes.NewReader(deferred.Raw)); err != nil {
+			return err
+		}
+
+		ret = append(ret, evt)
+		return nil
+	})
+
+	return ret, err
+}
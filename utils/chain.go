@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -8,70 +9,86 @@ import (
 	"strconv"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-state-types/abi"
-	"github.com/filecoin-project/go-state-types/builtin/v10/eam"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/vm"
 	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multicodec"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
 )
 
 type EXSignedMessage struct {
 	types.SignedMessage
-	MethodName   string
-	ParamJson    string
-	ReturnJson   string
-	Events       []EXEvent
-	GasUsed      int64
-	BaseFee      int64
+	MethodName string
+	ParamJson  string
+	ReturnJson string
+	Events     []EXEvent
+	GasUsed    int64
+	BaseFee    abi.TokenAmount
+	*GasCost
 	Status       string
 	ErrorMessage string
 }
 
 type EXPerMessage struct {
-	CID          cid.Cid
-	MethodName   string
-	ReturnJson   string
-	Events       []EXEvent
-	GasUsed      int64
+	CID        cid.Cid
+	MethodName string
+	ReturnJson string
+	Events     []EXEvent
+	GasUsed    int64
+	BaseFee    abi.TokenAmount
+	*GasCost
 	Status       string
 	ErrorMessage string
 }
 
 type smEXSignedMessage struct {
 	*types.RawSignedMessage
-	CID          cid.Cid
-	MethodName   string
-	ParamJson    string
-	ReturnJson   string
-	Events       []EXEvent
-	GasUsed      int64
-	BaseFee      int64
+	CID        cid.Cid
+	MethodName string
+	ParamJson  string
+	ReturnJson string
+	Events     []EXEvent
+	GasUsed    int64
+	BaseFee    abi.TokenAmount
+	*GasCost
 	Status       string
 	ErrorMessage string
 }
 
 type EXMessage struct {
 	types.Message
-	MethodName   string
-	ParamJson    string
-	ReturnJson   string
-	Events       []EXEvent
-	GasUsed      int64
-	BaseFee      int64
+	MethodName string
+	ParamJson  string
+	ReturnJson string
+	Events     []EXEvent
+	GasUsed    int64
+	BaseFee    abi.TokenAmount
+	*GasCost
 	Status       string
 	ErrorMessage string
 }
 
 type smEXMessageCid struct {
 	*types.RawMessage
-	CID          cid.Cid
-	MethodName   string
-	ParamJson    string
-	ReturnJson   string
-	Events       []EXEvent
-	GasUsed      int64
-	BaseFee      int64
+	CID        cid.Cid
+	MethodName string
+	ParamJson  string
+	ReturnJson string
+	Events     []EXEvent
+	GasUsed    int64
+	BaseFee    abi.TokenAmount
+	*GasCost
 	Status       string
 	ErrorMessage string
 }
@@ -79,6 +96,10 @@ type smEXMessageCid struct {
 type EXEvent struct {
 	Address address.Address
 	Topics  []EXEventEntry
+	// set when the event could be decoded, e.g. an EVM log matching a known ABI
+	Name      string   `json:",omitempty"`
+	Signature string   `json:",omitempty"`
+	Args      []ABIArg `json:",omitempty"`
 }
 
 type EXEventEntry struct {
@@ -86,12 +107,26 @@ type EXEventEntry struct {
 	Key   string
 	Codec uint64
 	Value string
+	// the value of a built-in actor event entry as JSON
+	Decoded json.RawMessage `json:",omitempty"`
 }
 
-type ExCreateExternalReturn struct {
-	ActorID       uint64
-	RobustAddress *address.Address
-	EthAddress    string
+// EXBlock is a block header together with its decoded messages.
+type EXBlock struct {
+	types.BlockHeader
+	BlsMessages   []*EXMessage
+	SecpkMessages []*EXSignedMessage
+	BlockHash     string
+}
+
+// EXTipSet is a decoded tipset: its blocks, and the receipts of the parent
+// tipset messages which were executed to produce its state.
+type EXTipSet struct {
+	Type           string `json:",omitempty"`
+	Height         abi.ChainEpoch
+	TipSet         types.TipSetKey
+	Blocks         []*EXBlock      `json:",omitempty"`
+	ParentReceipts []*EXPerMessage `json:",omitempty"`
 }
 
 func (sm *EXMessage) MarshalJSON() ([]byte, error) {
@@ -104,6 +139,7 @@ func (sm *EXMessage) MarshalJSON() ([]byte, error) {
 		Events:       sm.Events,
 		GasUsed:      sm.GasUsed,
 		BaseFee:      sm.BaseFee,
+		GasCost:      sm.GasCost,
 		Status:       sm.Status,
 		ErrorMessage: sm.ErrorMessage,
 	})
@@ -119,6 +155,7 @@ func (sm *EXSignedMessage) MarshalJSON() ([]byte, error) {
 		Events:           sm.Events,
 		GasUsed:          sm.GasUsed,
 		BaseFee:          sm.BaseFee,
+		GasCost:          sm.GasCost,
 		Status:           sm.Status,
 		ErrorMessage:     sm.ErrorMessage,
 	})
@@ -127,21 +164,75 @@ func (sm *EXSignedMessage) MarshalJSON() ([]byte, error) {
 var ChainExCmd = &cli.Command{
 	Name:  "chain",
 	Usage: "Interact with filecoin blockchain",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "abi-dir",
+			Usage: "directory of contract ABI json files used to decode FEVM calls and logs; <address>.json applies to one contract, other files to all",
+		},
+	},
+	Before: func(cctx *cli.Context) error {
+		if dir := cctx.String("abi-dir"); dir != "" {
+			return ABIs.LoadDir(dir)
+		}
+		return nil
+	},
 	Subcommands: []*cli.Command{
 		ChainGetBlockEX,
 		ChainGetTipsetCmd,
+		ChainTraceMessageCmd,
+		ChainGetMessageCmd,
+		ChainFollowCmd,
+		ChainExportCmd,
+		ChainEventsCmd,
+		ChainStatsCmd,
+		ChainBaseFeeCmd,
+		ChainMpoolCmd,
 	},
 }
 
+// EXTipSetView describes the tipset at a height. For a null round BlockCids
+// is empty and TipSet is the last tipset before the height.
+type EXTipSetView struct {
+	Height          int64
+	BlockCids       []cid.Cid
+	NullRound       bool
+	TipSetHeight    abi.ChainEpoch
+	TipSet          types.TipSetKey
+	ParentWeight    types.BigInt
+	ParentStateRoot cid.Cid
+	ParentBaseFee   abi.TokenAmount
+	Messages        []*EXTipSetMessage `json:",omitempty"`
+	Eth             *EXEthBlock        `json:",omitempty"`
+}
+
+// EXTipSetMessage is a message of a tipset as the VM applies it. Receipt is
+// only known once a child tipset has been built.
+type EXTipSetMessage struct {
+	CID        cid.Cid
+	Message    *types.Message
+	MethodName string
+	ParamJson  string
+	Receipt    *EXPerMessage `json:",omitempty"`
+}
+
 var ChainGetTipsetCmd = &cli.Command{
-	Name:    "get-tipset",
-	Aliases: []string{"gettipset"},
-	Usage:   "View Tipset",
+	Name:      "get-tipset",
+	Aliases:   []string{"gettipset"},
+	Usage:     "View Tipset",
+	ArgsUsage: "[height]",
 	Flags: []cli.Flag{
 		&cli.Int64Flag{
 			Name:  "height",
-			Usage: "Get tipset according to altitude",
-			Value: 1,
+			Usage: "Get tipset according to altitude, used when no height argument is given (default: chain head)",
+			Value: -1,
+		},
+		&cli.BoolFlag{
+			Name:  "full",
+			Usage: "list the tipset's messages in execution order with their receipts",
+		},
+		&cli.BoolFlag{
+			Name:  "eth",
+			Usage: "also render the tipset as an Ethereum block with its transactions and receipts",
 		},
 	},
 	Action: func(cctx *cli.Context) error {
@@ -154,14 +245,25 @@ var ChainGetTipsetCmd = &cli.Command{
 		defer closer()
 		ctx := ReqContext(cctx)
 
-		height, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		head, err := api.ChainHead(ctx)
 		if err != nil {
 			return err
 		}
 
+		height := cctx.Int64("height")
+		if cctx.Args().Present() {
+			height, err = strconv.ParseInt(cctx.Args().First(), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+		if height < 0 {
+			height = int64(head.Height())
+		}
+
 		var ts *types.TipSet
 
-		ts, err = api.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(height), types.EmptyTSK)
+		ts, err = api.ChainGetTipSetByHeight(ctx, abi.ChainEpoch(height), head.Key())
 
 		if err != nil {
 			return err
@@ -171,16 +273,45 @@ var ChainGetTipsetCmd = &cli.Command{
 			return errors.New("Tipset Not Found\n")
 		}
 
-		tipset := struct {
-			Height    int64
-			BlockCids []cid.Cid
-		}{}
-
-		tipset.Height = height
-		if height == int64(ts.Height()) {
+		first := ts.Blocks()[0]
+		tipset := EXTipSetView{
+			Height:          height,
+			NullRound:       int64(ts.Height()) != height,
+			TipSetHeight:    ts.Height(),
+			TipSet:          ts.Key(),
+			ParentWeight:    first.ParentWeight,
+			ParentStateRoot: first.ParentStateRoot,
+			ParentBaseFee:   first.ParentBaseFee,
+		}
+		if !tipset.NullRound {
 			tipset.BlockCids = ts.Cids()
-		} else {
-			tipset.BlockCids = nil
+		}
+
+		var api1 v1api.FullNode
+		if cctx.Bool("full") || cctx.Bool("eth") {
+			var closer1 jsonrpc.ClientCloser
+			api1, closer1, err = GetFullNodeAPIV1(cctx)
+			if err != nil {
+				return err
+			}
+			defer closer1()
+		}
+
+		if cctx.Bool("full") {
+			tipset.Messages, err = tipSetMessages(ctx, api, ts, func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+				return api1.ChainGetEvents(ctx, root)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		// null rounds have no Ethereum block
+		if cctx.Bool("eth") && !tipset.NullRound {
+			tipset.Eth, err = EthBlockView(ctx, api1, ts)
+			if err != nil {
+				return err
+			}
 		}
 
 		out, err := json.MarshalIndent(tipset, "", "  ")
@@ -194,6 +325,619 @@ var ChainGetTipsetCmd = &cli.Command{
 	},
 }
 
+// tipSetMessages lists the messages of ts the way the VM applies them: blocks
+// in tipset order, BLS before secp messages, duplicates and messages with an
+// out of sequence nonce left out. Receipts come from the child tipset, when
+// there is one, which lists exactly the applied messages.
+func tipSetMessages(ctx context.Context, api v0api.FullNode, ts *types.TipSet, getEvents func(ctx context.Context, root cid.Cid) ([]types.Event, error)) ([]*EXTipSetMessage, error) {
+	first := ts.Blocks()[0]
+	child, err := childTipSet(ctx, api, first)
+	if err != nil {
+		return nil, err
+	}
+	msgActor, _, err := BlockActorFuncs(ctx, api, first, child)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load tipset state: %w", err)
+	}
+	msgTxn, _, err := BlockMsigTxnFuncs(ctx, api, first)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load tipset state: %w", err)
+	}
+
+	var (
+		msgs   []lapi.Message
+		recpts []*types.MessageReceipt
+	)
+	if child != nil {
+		if msgs, err = api.ChainGetParentMessages(ctx, child.Blocks()[0].Cid()); err != nil {
+			return nil, xerrors.Errorf("failed to get messages: %w", err)
+		}
+		if recpts, err = api.ChainGetParentReceipts(ctx, child.Blocks()[0].Cid()); err != nil {
+			return nil, xerrors.Errorf("failed to get receipts: %w", err)
+		}
+		if len(msgs) != len(recpts) {
+			return nil, xerrors.Errorf("got %d messages but %d receipts", len(msgs), len(recpts))
+		}
+	} else if msgs, err = api.ChainGetMessagesInTipset(ctx, ts.Key()); err != nil {
+		return nil, xerrors.Errorf("failed to get messages: %w", err)
+	}
+
+	out := make([]*EXTipSetMessage, 0, len(msgs))
+	for _, m := range msgs {
+		exmsg := &EXTipSetMessage{CID: m.Cid, Message: m.Message}
+		exmsg.MethodName, exmsg.ParamJson = DecodeMessageParams(ctx, m.Message, msgActor, msgTxn)
+		out = append(out, exmsg)
+	}
+	if recpts != nil {
+		for i, r := range DecodeParentReceipts(ctx, msgs, recpts, first.ParentBaseFee, msgActor, msgTxn, getEvents) {
+			out[i].Receipt = r
+		}
+	}
+	return out, nil
+}
+
+var ChainTraceMessageCmd = &cli.Command{
+	Name:      "trace-message",
+	Aliases:   []string{"tracemessage"},
+	Usage:     "Replay a message and print its decoded invocation tree",
+	ArgsUsage: "[messageCid]",
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("must pass cid of message to trace")
+		}
+
+		mcid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		lookup, err := api.StateSearchMsg(ctx, mcid)
+		if err != nil {
+			return xerrors.Errorf("search message failed: %w", err)
+		}
+		if lookup == nil {
+			return xerrors.Errorf("message %s not found on chain", mcid)
+		}
+
+		res, err := api.StateReplay(ctx, types.EmptyTSK, mcid)
+		if err != nil {
+			return xerrors.Errorf("replay message failed: %w", err)
+		}
+
+		// the message was executed on top of the parent of the tipset carrying its receipt
+		execTs, err := api.ChainGetTipSet(ctx, lookup.TipSet)
+		if err != nil {
+			return xerrors.Errorf("get tipset failed: %w", err)
+		}
+		inclTs, err := api.ChainGetTipSet(ctx, execTs.Parents())
+		if err != nil {
+			return xerrors.Errorf("get tipset failed: %w", err)
+		}
+		getCode, err := MakeGetActorCodeFunc(ctx, apiActorStore(ctx, api), execTs, inclTs)
+		if err != nil {
+			return err
+		}
+
+		trace := NewMessageTrace(ctx, res.ExecutionTrace, getCode)
+		trace.Message = res.Msg
+		trace.Receipt = res.MsgRct
+		trace.Error = res.Error
+		trace.Duration = res.Duration
+
+		out, err := json.MarshalIndent(trace, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		afmt.Println(string(out))
+		return nil
+	},
+}
+
+// DecodeMessageParams resolves the receiver of msg with getActor and returns the
+// decoded method name and params. Calls proposed through a multisig are
+// decoded as well, resolving pending transactions with getTxn, which may be
+// nil.
+func DecodeMessageParams(ctx context.Context, msg *types.Message, getActor GetActorFunc, getTxn GetMsigTxnFunc) (methodName string, paramJson string) {
+	act, ok := getActor(ctx, msg.To)
+	if !ok {
+		return "", ""
+	}
+	code := act.Code
+
+	method, params, err := MethodAndParamsForMessage(msg, code)
+	if err != nil {
+		if msg.Method.String() == "3844450837" {
+			return "InvokeEVM", ""
+		}
+		return "Unknow", ""
+	}
+
+	if isEamCreate(code, msg.Method) {
+		if p, err := DecodeEamCreateParams(msg.Method, msg.Params); err == nil {
+			return method, p
+		}
+	} else if isMsigCall(code, msg.Method) {
+		if p, err := DecodeMsigParams(ctx, msg, getActor, getTxn); err == nil {
+			return method, p
+		}
+	} else if method == "InvokeContract" {
+		return method, DecodeInvokeContractParams(msg.To, act, msg.Params)
+	}
+	return method, params
+}
+
+// DecodeReturn resolves the receiver of msg with getActor and returns the
+// decoded method name and return value ret.
+func DecodeReturn(ctx context.Context, msg *types.Message, ret []byte, getActor GetActorFunc, getTxn GetMsigTxnFunc) (methodName string, returnJson string) {
+	act, ok := getActor(ctx, msg.To)
+	if !ok {
+		return "", ""
+	}
+	code := act.Code
+
+	method, _, err := MethodAndParamsForMessage(msg, code)
+	if err != nil {
+		return "", ""
+	}
+	returnJson, _, err = ParseReturn(ret, msg.Method, code)
+	if err != nil {
+		return method, ""
+	}
+
+	if isEamCreate(code, msg.Method) {
+		if r, err := DecodeEamCreateReturn(ret); err == nil {
+			return method, r
+		}
+	} else if isMsigCall(code, msg.Method) {
+		if r, err := DecodeMsigReturn(ctx, msg, ret, getActor, getTxn); err == nil && r != "" {
+			return method, r
+		}
+	} else if method == "InvokeContract" {
+		return method, DecodeInvokeContractReturn(msg.To, act, msg.Params, ret)
+	}
+	return method, returnJson
+}
+
+// DecodeReceipt decodes the receipt of a parent message executed with baseFee,
+// fetching its events through getEvents. Events which can't be fetched are
+// logged and left out.
+func DecodeReceipt(ctx context.Context, msg lapi.Message, recpt *types.MessageReceipt, baseFee abi.TokenAmount, getActor GetActorFunc, getTxn GetMsigTxnFunc, getEvents func(ctx context.Context, root cid.Cid) ([]types.Event, error)) *EXPerMessage {
+	exmsg := new(EXPerMessage)
+	exmsg.CID = msg.Cid
+	exmsg.GasUsed = recpt.GasUsed
+	exmsg.Status = recpt.ExitCode.String()
+	exmsg.BaseFee = baseFee
+	exmsg.GasCost = NewGasCost(msg.Message, recpt, baseFee)
+	exmsg.MethodName, exmsg.ReturnJson = DecodeReturn(ctx, msg.Message, recpt.Return, getActor, getTxn)
+
+	if eventsRoot := recpt.EventsRoot; eventsRoot != nil {
+		events, err := getEvents(ctx, *eventsRoot)
+		if err != nil {
+			log.Warnf("failed to get events of message %s: %s", msg.Cid, err)
+		} else {
+			exmsg.Events = DecodeEvents(ctx, events, getActor)
+		}
+	}
+
+	return exmsg
+}
+
+// DecodeEvents converts actor events into their EXEvent form. Built-in actor
+// events are labelled with their $type and their values decoded; EVM logs are
+// decoded against the ABI registry, resolving emitters with getActor.
+func DecodeEvents(ctx context.Context, events []types.Event, getActor GetActorFunc) []EXEvent {
+	var out []EXEvent
+	for _, evt := range events {
+		var exEvent EXEvent
+		exEvent.Address, _ = address.NewFromString("f0" + evt.Emitter.String())
+		builtinEvent := isBuiltinEvent(evt)
+		for _, e := range evt.Entries {
+			entry := EXEventEntry{Flags: e.Flags, Key: e.Key, Codec: e.Codec, Value: "0x" + hex.EncodeToString(e.Value)}
+			if builtinEvent && e.Codec == uint64(multicodec.Cbor) {
+				if decoded, err := DecodeEventValue(e.Key, e.Value); err == nil {
+					entry.Decoded = decoded
+					if e.Key == builtinEventTypeKey {
+						_ = json.Unmarshal(decoded, &exEvent.Name)
+					}
+				}
+			}
+			exEvent.Topics = append(exEvent.Topics, entry)
+		}
+		if topics, data, ok := evmLog(evt); ok {
+			act, _ := getActor(ctx, exEvent.Address)
+			if call, err := ABIs.DecodeLog(ethAddressesOf(exEvent.Address, act), topics, data); err == nil {
+				exEvent.Name = call.Name
+				exEvent.Signature = call.Signature
+				exEvent.Args = call.Args
+			}
+		}
+		out = append(out, exEvent)
+	}
+	return out
+}
+
+// evmLog extracts the topics and data of an event emitted by the EVM actor,
+// whose entries are keyed t1..t4 and d and carry raw values.
+func evmLog(evt types.Event) (topics [][]byte, data []byte, ok bool) {
+	topics = make([][]byte, 0, 4)
+	for _, e := range evt.Entries {
+		if e.Codec != cid.Raw {
+			return nil, nil, false
+		}
+		switch e.Key {
+		case "t1", "t2", "t3", "t4":
+			if len(e.Value) != 32 {
+				return nil, nil, false
+			}
+			topics = append(topics, e.Value)
+		case "d":
+			data = e.Value
+		default:
+			return nil, nil, false
+		}
+	}
+	return topics, data, len(topics) > 0
+}
+
+// DecodeTipSet fetches and decodes every block of ts along with the parent
+// receipts the tipset carries.
+func DecodeTipSet(ctx context.Context, api v0api.FullNode, ts *types.TipSet, getEvents func(ctx context.Context, root cid.Cid) ([]types.Event, error)) (*EXTipSet, error) {
+	out := &EXTipSet{
+		Height: ts.Height(),
+		TipSet: ts.Key(),
+	}
+
+	// all blocks of a tipset share the same parent state and parent receipts
+	first := ts.Blocks()[0]
+	child, err := childTipSet(ctx, api, first)
+	if err != nil {
+		return nil, err
+	}
+	msgActor, rcptActor, err := BlockActorFuncs(ctx, api, first, child)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load tipset state: %w", err)
+	}
+	msgTxn, rcptTxn, err := BlockMsigTxnFuncs(ctx, api, first)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load tipset state: %w", err)
+	}
+	msgRcpts, err := BlockMessageReceipts(ctx, api, child)
+	if err != nil {
+		return nil, err
+	}
+	rcptBaseFee, err := ParentBaseFee(ctx, api, first)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, blk := range ts.Blocks() {
+		exblk, err := DecodeBlockMessages(ctx, api, blk, msgActor, msgTxn, msgRcpts)
+		if err != nil {
+			return nil, err
+		}
+		out.Blocks = append(out.Blocks, exblk)
+	}
+
+	pMsgs, err := api.ChainGetParentMessages(ctx, first.Cid())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get parent messages: %w", err)
+	}
+	recpts, err := api.ChainGetParentReceipts(ctx, first.Cid())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get receipts: %w", err)
+	}
+	if len(pMsgs) != len(recpts) {
+		return nil, xerrors.Errorf("got %d parent messages but %d receipts", len(pMsgs), len(recpts))
+	}
+	out.ParentReceipts = DecodeParentReceipts(ctx, pMsgs, recpts, rcptBaseFee, rcptActor, rcptTxn, getEvents)
+
+	return out, nil
+}
+
+// DecodeBlockMessages fetches and decodes the messages included in blk.
+// Receipts of the messages, when known, are taken from rcpts.
+func DecodeBlockMessages(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader, getActor GetActorFunc, getTxn GetMsigTxnFunc, rcpts map[cid.Cid]*types.MessageReceipt) (*EXBlock, error) {
+	msgs, err := api.ChainGetBlockMessages(ctx, blk.Cid())
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get messages: %w", err)
+	}
+
+	exblk := &EXBlock{
+		BlockHeader:   *blk,
+		BlsMessages:   make([]*EXMessage, 0, len(msgs.BlsMessages)),
+		SecpkMessages: make([]*EXSignedMessage, 0, len(msgs.SecpkMessages)),
+		BlockHash:     blk.Cid().String(),
+	}
+	for _, msg := range msgs.BlsMessages {
+		exmsg := new(EXMessage)
+		exmsg.Message = *msg
+		exmsg.MethodName, exmsg.ParamJson = DecodeMessageParams(ctx, msg, getActor, getTxn)
+		exmsg.BaseFee = blk.ParentBaseFee
+		if r, ok := rcpts[msg.Cid()]; ok {
+			exmsg.GasUsed = r.GasUsed
+			exmsg.Status = r.ExitCode.String()
+			exmsg.GasCost = NewGasCost(msg, r, blk.ParentBaseFee)
+		}
+		exblk.BlsMessages = append(exblk.BlsMessages, exmsg)
+	}
+	for _, msg := range msgs.SecpkMessages {
+		exmsg := new(EXSignedMessage)
+		exmsg.SignedMessage = *msg
+		exmsg.MethodName, exmsg.ParamJson = DecodeMessageParams(ctx, msg.VMMessage(), getActor, getTxn)
+		exmsg.BaseFee = blk.ParentBaseFee
+		if r, ok := rcpts[msg.Cid()]; ok {
+			exmsg.GasUsed = r.GasUsed
+			exmsg.Status = r.ExitCode.String()
+			exmsg.GasCost = NewGasCost(msg.VMMessage(), r, blk.ParentBaseFee)
+		}
+		exblk.SecpkMessages = append(exblk.SecpkMessages, exmsg)
+	}
+	return exblk, nil
+}
+
+// eventFetchWorkers bounds the number of concurrent event requests made while
+// decoding receipts.
+const eventFetchWorkers = 16
+
+// DecodeParentReceipts decodes the receipts of pMsgs. Events are fetched up
+// front with a bounded worker pool; decoding itself stays sequential as state
+// tree lookups are not safe for concurrent use.
+func DecodeParentReceipts(ctx context.Context, pMsgs []lapi.Message, recpts []*types.MessageReceipt, baseFee abi.TokenAmount, getActor GetActorFunc, getTxn GetMsigTxnFunc, getEvents func(ctx context.Context, root cid.Cid) ([]types.Event, error)) []*EXPerMessage {
+	getEvents = prefetchEvents(ctx, recpts, getEvents)
+
+	out := make([]*EXPerMessage, 0, len(recpts))
+	for i, recpt := range recpts {
+		out = append(out, DecodeReceipt(ctx, pMsgs[i], recpt, baseFee, getActor, getTxn, getEvents))
+	}
+	return out
+}
+
+// prefetchEvents fetches the events of every receipt concurrently and returns
+// a lookup serving them, falling back to getEvents for unknown roots.
+func prefetchEvents(ctx context.Context, recpts []*types.MessageReceipt, getEvents func(ctx context.Context, root cid.Cid) ([]types.Event, error)) func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+	type result struct {
+		events []types.Event
+		err    error
+	}
+	results := make(map[cid.Cid]*result)
+	for _, r := range recpts {
+		if r.EventsRoot != nil {
+			results[*r.EventsRoot] = new(result)
+		}
+	}
+
+	var eg errgroup.Group
+	eg.SetLimit(eventFetchWorkers)
+	for root, res := range results {
+		root, res := root, res
+		eg.Go(func() error {
+			res.events, res.err = getEvents(ctx, root)
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	return func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+		if res, ok := results[root]; ok {
+			return res.events, res.err
+		}
+		return getEvents(ctx, root)
+	}
+}
+
+// BlockActorFuncs returns actor lookups for the two state transitions a
+// block takes part in. msgActor resolves the receivers of the block's own
+// messages, which are executed on top of the block's parent state; rcptActor
+// resolves the receivers of the parent messages whose receipts the block
+// carries, see BlockReceiptActorFunc. Both fall back to the pre-transition
+// state so actors deleted during execution are still found, and both cache
+// lookups per address. child is the tipset built on blk's tipset, as returned
+// by childTipSet, or nil if there is none yet.
+func BlockActorFuncs(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader, child *types.TipSet) (msgActor, rcptActor GetActorFunc, err error) {
+	rcptActor, err = BlockReceiptActorFunc(ctx, api, blk)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a single block tipset is enough to address the block's parent state
+	self, err := types.NewTipSet([]*types.BlockHeader{blk})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the block's own messages only have a post-state once a child tipset
+	// has been built on top of the tipset containing it
+	if child == nil {
+		child = self
+	}
+	getActor, err := MakeGetActorFunc(ctx, apiActorStore(ctx, api), child, self)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgActor = CacheActorFunc(getActor)
+
+	return msgActor, rcptActor, nil
+}
+
+// BlockReceiptActorFunc returns the actor lookup for the parent messages
+// whose receipts blk carries: the block's parent state, falling back to the
+// state they were executed on.
+func BlockReceiptActorFunc(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader) (GetActorFunc, error) {
+	if blk.Height == 0 {
+		return func(context.Context, address.Address) (*types.Actor, bool) { return nil, false }, nil
+	}
+
+	self, err := types.NewTipSet([]*types.BlockHeader{blk})
+	if err != nil {
+		return nil, err
+	}
+	parent, err := api.ChainGetTipSet(ctx, types.NewTipSetKey(blk.Parents...))
+	if err != nil {
+		return nil, xerrors.Errorf("get parent tipset failed: %w", err)
+	}
+	getActor, err := MakeGetActorFunc(ctx, apiActorStore(ctx, api), self, parent)
+	if err != nil {
+		return nil, err
+	}
+	return CacheActorFunc(getActor), nil
+}
+
+// BlockMsigTxnFuncs returns pending multisig transaction lookups for the two
+// state transitions a block takes part in, see BlockActorFuncs. Both look at
+// the state the messages are executed on, where the transactions an approval
+// executes still exist.
+func BlockMsigTxnFuncs(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader) (msgTxn, rcptTxn GetMsigTxnFunc, err error) {
+	store := apiActorStore(ctx, api)
+
+	self, err := types.NewTipSet([]*types.BlockHeader{blk})
+	if err != nil {
+		return nil, nil, err
+	}
+	getActor, err := MakeGetActorFunc(ctx, store, self, self)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgTxn = MakeGetMsigTxnFunc(store, CacheActorFunc(getActor))
+
+	if blk.Height > 0 {
+		parent, err := api.ChainGetTipSet(ctx, types.NewTipSetKey(blk.Parents...))
+		if err != nil {
+			return nil, nil, xerrors.Errorf("get parent tipset failed: %w", err)
+		}
+		getActor, err := MakeGetActorFunc(ctx, store, parent, parent)
+		if err != nil {
+			return nil, nil, err
+		}
+		rcptTxn = MakeGetMsigTxnFunc(store, CacheActorFunc(getActor))
+	}
+
+	return msgTxn, rcptTxn, nil
+}
+
+// childTipSet returns the canonical tipset built on the tipset containing blk,
+// or nil if blk is not canonical or nothing has been built on it yet.
+func childTipSet(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader) (*types.TipSet, error) {
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if blk.Height >= head.Height() {
+		return nil, nil
+	}
+	ts, err := api.ChainGetTipSetByHeight(ctx, blk.Height, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("get tipset at height %d: %w", blk.Height, err)
+	}
+	if ts.Height() != blk.Height || !ts.Contains(blk.Cid()) {
+		return nil, nil
+	}
+	return nextTipSet(ctx, api, ts)
+}
+
+// BlockMessageReceipts returns the receipts of the messages included in a
+// block, keyed by message cid. They are carried by child, the tipset built on
+// the block's tipset as returned by childTipSet, so nothing is returned until
+// one exists.
+func BlockMessageReceipts(ctx context.Context, api v0api.FullNode, child *types.TipSet) (map[cid.Cid]*types.MessageReceipt, error) {
+	if child == nil {
+		return nil, nil
+	}
+	first := child.Blocks()[0].Cid()
+	msgs, err := api.ChainGetParentMessages(ctx, first)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get child parent messages: %w", err)
+	}
+	recpts, err := api.ChainGetParentReceipts(ctx, first)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get child parent receipts: %w", err)
+	}
+	if len(msgs) != len(recpts) {
+		return nil, xerrors.Errorf("got %d parent messages but %d receipts", len(msgs), len(recpts))
+	}
+	out := make(map[cid.Cid]*types.MessageReceipt, len(msgs))
+	for i, m := range msgs {
+		out[m.Cid] = recpts[i]
+	}
+	return out, nil
+}
+
+// ParentBaseFee returns the base fee the parent messages of blk were executed
+// with, which is the one recorded in the parent tipset's headers.
+func ParentBaseFee(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader) (abi.TokenAmount, error) {
+	if blk.Height == 0 {
+		return big.Zero(), nil
+	}
+	parent, err := api.ChainGetBlock(ctx, blk.Parents[0])
+	if err != nil {
+		return big.Zero(), xerrors.Errorf("get parent block failed: %w", err)
+	}
+	return parent.ParentBaseFee, nil
+}
+
+// GasCost is the gas outcome of an executed message in attoFIL. TotalCost is
+// what the sender paid; MinerPenalty is charged to the block miner.
+type GasCost struct {
+	BaseFeeBurn        abi.TokenAmount
+	OverEstimationBurn abi.TokenAmount
+	MinerPenalty       abi.TokenAmount
+	MinerTip           abi.TokenAmount
+	Refund             abi.TokenAmount
+	TotalCost          abi.TokenAmount
+}
+
+// NewGasCost applies the protocol's gas charging rules to msg executed with
+// baseFee.
+func NewGasCost(msg *types.Message, recpt *types.MessageReceipt, baseFee abi.TokenAmount) *GasCost {
+	out := vm.ComputeGasOutputs(recpt.GasUsed, msg.GasLimit, baseFee, msg.GasFeeCap, msg.GasPremium, true)
+	return &GasCost{
+		BaseFeeBurn:        out.BaseFeeBurn,
+		OverEstimationBurn: out.OverEstimationBurn,
+		MinerPenalty:       out.MinerPenalty,
+		MinerTip:           out.MinerTip,
+		Refund:             out.Refund,
+		TotalCost:          big.Sum(out.BaseFeeBurn, out.OverEstimationBurn, out.MinerTip),
+	}
+}
+
+// nextTipSet returns the canonical tipset built directly on ts, skipping null
+// rounds, or nil if there is none yet.
+func nextTipSet(ctx context.Context, api v0api.FullNode, ts *types.TipSet) (*types.TipSet, error) {
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for h := ts.Height() + 1; h <= head.Height(); h++ {
+		next, err := api.ChainGetTipSetByHeight(ctx, h, head.Key())
+		if err != nil {
+			return nil, err
+		}
+		if next.Height() <= ts.Height() {
+			// null round
+			continue
+		}
+		if next.Parents() != ts.Key() {
+			return nil, nil
+		}
+		return next, nil
+	}
+	return nil, nil
+}
+
+func apiActorStore(ctx context.Context, api v0api.FullNode) adt.Store {
+	tbs := blockstore.NewTieredBstore(blockstore.NewAPIBlockstore(api), blockstore.NewMemory())
+	return adt.WrapStore(ctx, cbor.NewCborStore(tbs))
+}
+
 var ChainGetBlockEX = &cli.Command{
 	Name:      "get-block",
 	Aliases:   []string{"getblock"},
@@ -204,6 +948,10 @@ var ChainGetBlockEX = &cli.Command{
 			Name:  "raw",
 			Usage: "print just the raw block header",
 		},
+		&cli.BoolFlag{
+			Name:  "eth",
+			Usage: "also render the block's tipset as an Ethereum block with its transactions and receipts",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		afmt := NewAppFmt(cctx.App)
@@ -230,10 +978,6 @@ var ChainGetBlockEX = &cli.Command{
 			return xerrors.Errorf("get block failed: %w", err)
 		}
 
-		ts, err := api.ChainGetTipSetByHeight(ctx, blk.Height, types.EmptyTSK)
-		if err != nil {
-			return xerrors.Errorf("get block failed: %w", err)
-		}
 		if cctx.Bool("raw") {
 			out, err := json.MarshalIndent(blk, "", "  ")
 			if err != nil {
@@ -244,10 +988,58 @@ var ChainGetBlockEX = &cli.Command{
 			return nil
 		}
 
-		msgs, err := api.ChainGetBlockMessages(ctx, bcid)
+		// one V1 session serves every event request of the block
+		api1, closer1, err := GetFullNodeAPIV1(cctx)
 		if err != nil {
-			return xerrors.Errorf("failed to get messages: %w", err)
+			return err
+		}
+		defer closer1()
+
+		child, err := childTipSet(ctx, api, blk)
+		if err != nil {
+			return err
+		}
+		msgActor, rcptActor, err := BlockActorFuncs(ctx, api, blk, child)
+		if err != nil {
+			return xerrors.Errorf("failed to load block state: %w", err)
+		}
+		msgTxn, rcptTxn, err := BlockMsigTxnFuncs(ctx, api, blk)
+		if err != nil {
+			return xerrors.Errorf("failed to load block state: %w", err)
+		}
+		msgRcpts, err := BlockMessageReceipts(ctx, api, child)
+		if err != nil {
+			log.Warn(err)
+		}
+		rcptBaseFee, err := ParentBaseFee(ctx, api, blk)
+		if err != nil {
+			return err
 		}
+
+		exblk, err := DecodeBlockMessages(ctx, api, blk, msgActor, msgTxn, msgRcpts)
+		if err != nil {
+			return err
+		}
+		exblk.BlockHash = blockhash
+
+		cblock := struct {
+			*EXBlock
+			Rewards        *EXBlockRewards `json:",omitempty"`
+			ParentReceipts []*EXPerMessage
+			Eth            *EXEthBlock `json:",omitempty"`
+		}{
+			EXBlock:        exblk,
+			ParentReceipts: make([]*EXPerMessage, 0),
+		}
+
+		// the genesis block has no parent state to pay it from
+		if blk.Height > 0 {
+			cblock.Rewards, err = DecodeBlockRewards(ctx, api, exblk, rcptActor, msgRcpts)
+			if err != nil {
+				return xerrors.Errorf("failed to compute block rewards: %w", err)
+			}
+		}
+
 		pMsgs, err := api.ChainGetParentMessages(ctx, bcid)
 		if err != nil {
 			log.Warn(err)
@@ -260,141 +1052,28 @@ var ChainGetBlockEX = &cli.Command{
 			//return xerrors.Errorf("failed to get receipts: %w", err)
 		}
 
-		cblock := struct {
-			types.BlockHeader
-			BlsMessages    []*EXMessage
-			SecpkMessages  []*EXSignedMessage
-			ParentReceipts []*EXPerMessage
-			BlockHash      string
-		}{}
-
-		blsMessages := make([]*EXMessage, 0)
-		for _, msg := range msgs.BlsMessages {
-			exmsg := new(EXMessage)
-			exmsg.Message = *msg
-			act, err := api.StateGetActor(ctx, msg.To, ts.Key())
-			if err == nil {
-				method, params, err := MethodAndParamsForMessage(msg.VMMessage(), act.Code)
-				if err == nil {
-					exmsg.MethodName = method
-					if method == "CreateExternal" {
-						exmsg.ParamJson = "{\"params\":" + "\"0x" + hex.EncodeToString(msg.VMMessage().Params)[6:len(hex.EncodeToString(msg.VMMessage().Params))-1] + "\"}"
-					} else if method == "InvokeContract" {
-						exmsg.ParamJson = "{\"params\":" + "\"0x" + hex.EncodeToString(msg.VMMessage().Params) + "\"}"
-					} else {
-						exmsg.ParamJson = params
-					}
-				} else {
-					if msg.VMMessage().Method.String() == "3844450837" {
-						exmsg.MethodName = "InvokeEVM"
-					} else {
-						exmsg.MethodName = "Unknow"
-					}
-				}
+		if len(pMsgs) == len(recpts) {
+			cblock.ParentReceipts = DecodeParentReceipts(ctx, pMsgs, recpts, rcptBaseFee, rcptActor, rcptTxn,
+				func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+					return api1.ChainGetEvents(ctx, root)
+				})
+		}
 
+		if cctx.Bool("eth") {
+			// the Ethereum block is the canonical tipset including the block
+			ts, err := api.ChainGetTipSetByHeight(ctx, blk.Height, types.EmptyTSK)
+			if err != nil {
+				return err
 			}
-			exmsg.BaseFee = blk.ParentBaseFee.Int64()
-			blsMessages = append(blsMessages, exmsg)
-		}
-
-		secpkMessages := make([]*EXSignedMessage, 0)
-		for _, msg := range msgs.SecpkMessages {
-			exmsg := new(EXSignedMessage)
-			exmsg.SignedMessage = *msg
-			act, err := api.StateGetActor(ctx, msg.Message.To, ts.Key())
-			if err == nil {
-				method, params, err := MethodAndParamsForMessage(msg.VMMessage(), act.Code)
-				if err == nil {
-					exmsg.MethodName = method
-					if method == "CreateExternal" {
-						exmsg.ParamJson = "{\"params\":" + "\"0x" + hex.EncodeToString(msg.VMMessage().Params)[6:len(hex.EncodeToString(msg.VMMessage().Params))-1] + "\"}"
-					} else if method == "InvokeContract" {
-						exmsg.ParamJson = "{\"params\":" + "\"0x" + hex.EncodeToString(msg.VMMessage().Params) + "\"}"
-					} else {
-						exmsg.ParamJson = params
-					}
-				} else {
-					if msg.VMMessage().Method.String() == "3844450837" {
-						exmsg.MethodName = "InvokeEVM"
-					} else {
-						exmsg.MethodName = "Unknow"
-					}
-				}
-			}
-			exmsg.BaseFee = blk.ParentBaseFee.Int64()
-			secpkMessages = append(secpkMessages, exmsg)
-		}
-		
-		parentReceipts := make([]*EXPerMessage, 0)
-		if len(pMsgs) == len(recpts){
-			for i, recpt := range recpts {
-				var exmsg = new(EXPerMessage);
-				exmsg.CID = pMsgs[i].Cid
-				act, err := api.StateGetActor(ctx, pMsgs[i].Message.To, types.EmptyTSK)
-				if err == nil {
-					method, _, err := MethodAndParamsForMessage(pMsgs[i].Message.VMMessage(), act.Code)
-					if err == nil {
-						exmsg.MethodName = method
-						exmsg.GasUsed = recpt.GasUsed
-						exmsg.Status = recpt.ExitCode.String()
-						returnJson, _, err := ParseReturn(recpt.Return, pMsgs[i].Message.VMMessage().Method, act.Code)
-						if err == nil {
-							if method == "CreateExternal" {
-								createExternalReturn := new(eam.CreateExternalReturn)
-								if err := json.Unmarshal([]byte(returnJson), &createExternalReturn); err == nil {
-									exCreateExternalReturn := ExCreateExternalReturn{
-										ActorID:       createExternalReturn.ActorID,
-										RobustAddress: createExternalReturn.RobustAddress,
-										EthAddress:    "0x" + hex.EncodeToString(createExternalReturn.EthAddress[:]),
-									}
-									out, err := json.MarshalIndent(exCreateExternalReturn, "", "  ")
-									if err != nil {
-										exmsg.ReturnJson = returnJson
-									} else {
-										exmsg.ReturnJson = string(out)
-									}
-		
-								} else {
-									exmsg.ReturnJson = returnJson
-								}
-							} else if method == "InvokeContract" {
-								exmsg.ReturnJson = "{\"return\":" + "\"0x" + hex.EncodeToString(recpt.Return) + "\"}"
-							} else {
-								exmsg.ReturnJson = returnJson
-							}
-						}
-					}
-					if eventsRoot := recpt.EventsRoot; eventsRoot != nil {
-						api2, closer2, err := GetFullNodeAPIV1(cctx)
-						if err != nil {
-							return err
-						}
-						defer closer2()
-	
-						events, err := api2.ChainGetEvents(ctx, *eventsRoot)
-						if err == nil {
-							for _, evt := range events {
-								var exEvent EXEvent
-								exEvent.Address, _ = address.NewFromString("f0" + evt.Emitter.String())
-								for _, e := range evt.Entries {
-									exEvent.Topics = append(exEvent.Topics, EXEventEntry{e.Flags, e.Key, e.Codec, "0x" + hex.EncodeToString(e.Value)})
-								}
-								exmsg.Events = append(exmsg.Events, exEvent)
-							}
-						}
-					}
-					
+			if ts.Height() == blk.Height && ts.Contains(bcid) {
+				if cblock.Eth, err = EthBlockView(ctx, api1, ts); err != nil {
+					return err
 				}
-				parentReceipts = append(parentReceipts, exmsg);
+			} else {
+				log.Warnf("block %s is not in the canonical chain, it has no Ethereum block", bcid)
 			}
 		}
 
-		cblock.BlockHeader = *blk
-		cblock.BlsMessages = blsMessages
-		cblock.SecpkMessages = secpkMessages
-		cblock.ParentReceipts = parentReceipts
-		cblock.BlockHash = blockhash
-
 		out, err := json.MarshalIndent(cblock, "", "  ")
 		if err != nil {
 			return err
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/builtin/v14/eam"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/ipfs/go-cid"
+)
+
+// ExEamCreateParams is the decoded form of the params of the Ethereum
+// Address Manager's Create, Create2 and CreateExternal methods.
+type ExEamCreateParams struct {
+	Initcode string
+	Nonce    *uint64 `json:",omitempty"`
+	Salt     string  `json:",omitempty"`
+}
+
+// ExEamCreateReturn is the decoded form of the return of the Ethereum Address
+// Manager's Create, Create2 and CreateExternal methods. DelegatedAddress is
+// the f410 address of the deployed contract.
+type ExEamCreateReturn struct {
+	ActorID          uint64
+	RobustAddress    *address.Address
+	EthAddress       string
+	DelegatedAddress address.Address
+}
+
+func isEamCreate(code cid.Cid, method abi.MethodNum) bool {
+	if _, family, err := ActorNameAndFamilyFromCode(code); err != nil || family != manifest.EamKey {
+		return false
+	}
+	switch method {
+	case builtin.MethodsEAM.Create, builtin.MethodsEAM.Create2, builtin.MethodsEAM.CreateExternal:
+		return true
+	}
+	return false
+}
+
+// DecodeEamCreateParams decodes the params of an EAM create method.
+func DecodeEamCreateParams(method abi.MethodNum, params []byte) (string, error) {
+	var out ExEamCreateParams
+	switch method {
+	case builtin.MethodsEAM.Create:
+		var p eam.CreateParams
+		if err := p.UnmarshalCBOR(bytes.NewReader(params)); err != nil {
+			return "", err
+		}
+		out.Initcode = "0x" + hex.EncodeToString(p.Initcode)
+		out.Nonce = &p.Nonce
+	case builtin.MethodsEAM.Create2:
+		var p eam.Create2Params
+		if err := p.UnmarshalCBOR(bytes.NewReader(params)); err != nil {
+			return "", err
+		}
+		out.Initcode = "0x" + hex.EncodeToString(p.Initcode)
+		out.Salt = "0x" + hex.EncodeToString(p.Salt[:])
+	case builtin.MethodsEAM.CreateExternal:
+		var p abi.CborBytes
+		if err := p.UnmarshalCBOR(bytes.NewReader(params)); err != nil {
+			return "", err
+		}
+		out.Initcode = "0x" + hex.EncodeToString(p)
+	default:
+		return "", fmt.Errorf("method %d is not an EAM create method", method)
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DecodeEamCreateReturn decodes the return of an EAM create method. All of
+// them share the same encoding.
+func DecodeEamCreateReturn(ret []byte) (string, error) {
+	var r eam.CreateReturn
+	if err := r.UnmarshalCBOR(bytes.NewReader(ret)); err != nil {
+		return "", err
+	}
+	delegated, err := address.NewDelegatedAddress(builtin.EthereumAddressManagerActorID, r.EthAddress[:])
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.Marshal(ExEamCreateReturn{
+		ActorID:          r.ActorID,
+		RobustAddress:    r.RobustAddress,
+		EthAddress:       "0x" + hex.EncodeToString(r.EthAddress[:]),
+		DelegatedAddress: delegated,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
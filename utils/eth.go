@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"context"
+
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// EXEthBlock is a tipset as the node's Ethereum JSON-RPC API serves it: the
+// block with full transaction objects plus the receipts of those
+// transactions, which carry their logs and topics.
+type EXEthBlock struct {
+	ethtypes.EthBlock
+	Receipts []*lapi.EthTxReceipt `json:"receipts"`
+}
+
+// EXEthTransaction is a message as the node's Ethereum JSON-RPC API serves it.
+// Messages sent from native Filecoin addresses get masked ID addresses
+// (0xff00...) as from/to.
+type EXEthTransaction struct {
+	Transaction *ethtypes.EthTx    `json:"transaction"`
+	Receipt     *lapi.EthTxReceipt `json:"receipt"`
+}
+
+// EthBlockView fetches the Ethereum view of ts. Its block hash is derived
+// from the tipset key, so every block of the tipset maps to the same view.
+func EthBlockView(ctx context.Context, api v1api.FullNode, ts *types.TipSet) (*EXEthBlock, error) {
+	kcid, err := ts.Key().Cid()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := ethtypes.EthHashFromCid(kcid)
+	if err != nil {
+		return nil, err
+	}
+
+	blk, err := api.EthGetBlockByHash(ctx, hash, true)
+	if err != nil {
+		return nil, xerrors.Errorf("get eth block %s failed: %w", hash, err)
+	}
+	rcpts, err := api.EthGetBlockReceipts(ctx, ethtypes.EthBlockNumberOrHash{BlockHash: &hash})
+	if err != nil {
+		return nil, xerrors.Errorf("get eth block receipts %s failed: %w", hash, err)
+	}
+	if rcpts == nil {
+		rcpts = make([]*lapi.EthTxReceipt, 0)
+	}
+	return &EXEthBlock{EthBlock: blk, Receipts: rcpts}, nil
+}
+
+// EthTransactionView fetches the Ethereum view of the message mcid, or nil
+// when the node has no transaction hash for it.
+func EthTransactionView(ctx context.Context, api v1api.FullNode, mcid cid.Cid) (*EXEthTransaction, error) {
+	hash, err := api.EthGetTransactionHashByCid(ctx, mcid)
+	if err != nil {
+		return nil, xerrors.Errorf("get transaction hash of %s failed: %w", mcid, err)
+	}
+	if hash == nil {
+		return nil, nil
+	}
+
+	tx, err := api.EthGetTransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, xerrors.Errorf("get eth transaction %s failed: %w", hash, err)
+	}
+	rcpt, err := api.EthGetTransactionReceipt(ctx, *hash)
+	if err != nil {
+		return nil, xerrors.Errorf("get eth transaction receipt %s failed: %w", hash, err)
+	}
+	return &EXEthTransaction{Transaction: tx, Receipt: rcpt}, nil
+}
@@ -0,0 +1,422 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multicodec"
+	"github.com/urfave/cli/v2"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
+)
+
+// builtinEventTypeKey is the entry naming the type of events emitted by
+// built-in actors (FIP-0049), e.g. "sector-activated" or "claim".
+const builtinEventTypeKey = "$type"
+
+// builtinEventEntryTypes lists the entries of built-in actor events whose
+// values can't be told apart from plain CBOR, such as bigints and bitfields
+// which are encoded as byte strings and actor IDs which are plain integers.
+// Everything else, and a typed entry whose value doesn't decode as its type,
+// is decoded generically.
+var builtinEventEntryTypes = map[string]reflect.Type{
+	"balance":  reflect.TypeOf(abi.TokenAmount{}),
+	"verifier": reflect.TypeOf(eventActorID{}),
+	"client":   reflect.TypeOf(eventActorID{}),
+	"provider": reflect.TypeOf(eventActorID{}),
+	"sectors":  reflect.TypeOf(bitfield.BitField{}),
+}
+
+// eventActorID is an actor ID entry of a built-in actor event, rendered as
+// its f0 address.
+type eventActorID struct {
+	address.Address
+}
+
+func (a *eventActorID) UnmarshalCBOR(r io.Reader) error {
+	maj, extra, err := cbg.NewCborReader(r).ReadHeader()
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return xerrors.Errorf("wrong type for actor id: %d", maj)
+	}
+	a.Address, err = address.NewIDAddress(extra)
+	return err
+}
+
+// maximum nesting of generically decoded CBOR values
+const maxEventValueDepth = 16
+
+// isBuiltinEvent reports whether evt is a built-in actor event, which carries
+// its type in a CBOR encoded "$type" entry.
+func isBuiltinEvent(evt types.Event) bool {
+	for _, e := range evt.Entries {
+		if e.Key == builtinEventTypeKey && e.Codec == uint64(multicodec.Cbor) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeEventValue renders the CBOR value of a built-in actor event entry as
+// JSON. Integers are numbers, bigints decimal strings, actor IDs f0
+// addresses, CIDs {"/": cid}, byte strings 0x hex and bitfields use the same
+// form as decoded params.
+func DecodeEventValue(key string, value []byte) (json.RawMessage, error) {
+	if t, ok := builtinEventEntryTypes[key]; ok {
+		p, ok := reflect.New(t).Interface().(cbg.CBORUnmarshaler)
+		if !ok {
+			return nil, xerrors.Errorf("%s does not implement CBORUnmarshaler", t)
+		}
+		if err := p.UnmarshalCBOR(bytes.NewReader(value)); err == nil {
+			return MarshalWithOverrides(reflect.ValueOf(p).Elem().Interface(), map[reflect.Type]marshaller{
+				reflect.TypeOf(bitfield.BitField{}): bitfieldCountMarshaller,
+			})
+		}
+	}
+
+	br := bytes.NewReader(value)
+	v, err := readCborValue(cbg.NewCborReader(br), 0)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding %s: %w", key, err)
+	}
+	if br.Len() != 0 {
+		return nil, xerrors.Errorf("decoding %s: %d trailing bytes", key, br.Len())
+	}
+	return json.Marshal(v)
+}
+
+func readCborValue(cr *cbg.CborReader, depth int) (interface{}, error) {
+	if depth > maxEventValueDepth {
+		return nil, xerrors.Errorf("value nested too deeply")
+	}
+
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	switch maj {
+	case cbg.MajUnsignedInt:
+		return extra, nil
+	case cbg.MajNegativeInt:
+		if extra > 1<<63-1 {
+			return nil, xerrors.Errorf("negative integer out of range")
+		}
+		return -1 - int64(extra), nil
+	case cbg.MajByteString, cbg.MajTextString:
+		if extra > cbg.ByteArrayMaxLen {
+			return nil, xerrors.Errorf("string too long: %d", extra)
+		}
+		buf := make([]byte, extra)
+		if _, err := io.ReadFull(cr, buf); err != nil {
+			return nil, err
+		}
+		if maj == cbg.MajTextString {
+			return string(buf), nil
+		}
+		return "0x" + hex.EncodeToString(buf), nil
+	case cbg.MajArray:
+		if extra > cbg.MaxLength {
+			return nil, xerrors.Errorf("array too long: %d", extra)
+		}
+		out := make([]interface{}, 0, extra)
+		for i := uint64(0); i < extra; i++ {
+			v, err := readCborValue(cr, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case cbg.MajMap:
+		if extra > cbg.MaxLength {
+			return nil, xerrors.Errorf("map too long: %d", extra)
+		}
+		out := make(map[string]interface{}, extra)
+		for i := uint64(0); i < extra; i++ {
+			k, err := readCborValue(cr, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readCborValue(cr, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(k)] = v
+		}
+		return out, nil
+	case cbg.MajTag:
+		if extra != 42 {
+			return nil, xerrors.Errorf("unsupported tag %d", extra)
+		}
+		maj, n, err := cr.ReadHeader()
+		if err != nil {
+			return nil, err
+		}
+		if maj != cbg.MajByteString || n == 0 || n > cbg.ByteArrayMaxLen {
+			return nil, xerrors.Errorf("invalid cid")
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(cr, buf); err != nil {
+			return nil, err
+		}
+		// dag-cbor prefixes cids with the identity multibase
+		if buf[0] != 0 {
+			return nil, xerrors.Errorf("invalid cid multibase prefix 0x%02x", buf[0])
+		}
+		c, err := cid.Cast(buf[1:])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"/": c.String()}, nil
+	case cbg.MajOther:
+		switch extra {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+		return nil, xerrors.Errorf("unsupported simple value %d", extra)
+	}
+	return nil, xerrors.Errorf("unsupported major type %d", maj)
+}
+
+// EXEventRecord is an event found by the events command. Height and TipSet are
+// those of the tipset carrying the receipt of the emitting message, which is
+// the first non-null tipset after the one including it.
+type EXEventRecord struct {
+	Height abi.ChainEpoch
+	TipSet types.TipSetKey
+	MsgCid cid.Cid
+	// position of the event among the events of its message
+	EventIndex int
+	EXEvent
+}
+
+// eventFilter matches raw events before they are decoded. Empty fields match
+// every event.
+type eventFilter struct {
+	emitters map[abi.ActorID]struct{}
+	topic0   []byte
+	key      string
+}
+
+func (f *eventFilter) match(evt types.Event) bool {
+	if len(f.emitters) > 0 {
+		if _, ok := f.emitters[evt.Emitter]; !ok {
+			return false
+		}
+	}
+	if f.topic0 != nil {
+		found := false
+		for _, e := range evt.Entries {
+			if e.Key == "t1" && bytes.Equal(e.Value, f.topic0) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.key != "" {
+		found := false
+		for _, e := range evt.Entries {
+			if e.Key == f.key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTopic0 accepts a 0x prefixed 32 byte topic or an event signature such
+// as "Transfer(address,address,uint256)".
+func parseTopic0(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "0x") {
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != 32 {
+			return nil, xerrors.Errorf("topic must be 32 bytes, got %d", len(b))
+		}
+		return b, nil
+	}
+	if !strings.Contains(s, "(") {
+		return nil, xerrors.Errorf("expected a 0x topic or an event signature, got %q", s)
+	}
+	return keccak256([]byte(strings.ReplaceAll(s, " ", ""))), nil
+}
+
+var ChainEventsCmd = &cli.Command{
+	Name:  "events",
+	Usage: "Stream actor events over a height range as NDJSON",
+	Description: `Collects the events in the receipts carried by every non-null tipset in the
+range and prints one record per event, decoded like get-block does. A message's
+events are carried by the first non-null tipset after the one including it.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "from",
+			Usage:    "first height to collect events from",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:  "to",
+			Usage: "last height to collect events from (default: chain head)",
+			Value: -1,
+		},
+		&cli.StringSliceFlag{
+			Name:  "emitter",
+			Usage: "only events emitted by this actor, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "topic0",
+			Usage: "only EVM logs with this first topic, given as 0x hex or event signature",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "only events with an entry of this key, e.g. $type or t1",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		api1, closer1, err := GetFullNodeAPIV1(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer1()
+		ctx := ReqContext(cctx)
+
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+
+		filter := &eventFilter{key: cctx.String("key")}
+		for _, e := range cctx.StringSlice("emitter") {
+			addr, err := address.NewFromString(e)
+			if err != nil {
+				return err
+			}
+			idAddr, err := api.StateLookupID(ctx, addr, head.Key())
+			if err != nil {
+				return xerrors.Errorf("resolve emitter %s: %w", addr, err)
+			}
+			id, err := address.IDFromAddress(idAddr)
+			if err != nil {
+				return err
+			}
+			if filter.emitters == nil {
+				filter.emitters = make(map[abi.ActorID]struct{})
+			}
+			filter.emitters[abi.ActorID(id)] = struct{}{}
+		}
+		if t := cctx.String("topic0"); t != "" {
+			if filter.topic0, err = parseTopic0(t); err != nil {
+				return err
+			}
+		}
+
+		from := abi.ChainEpoch(cctx.Int64("from"))
+		to := head.Height()
+		if cctx.Int64("to") >= 0 && abi.ChainEpoch(cctx.Int64("to")) < to {
+			to = abi.ChainEpoch(cctx.Int64("to"))
+		}
+		if from > to {
+			return xerrors.Errorf("--from %d is after --to %d", from, to)
+		}
+		last, err := api.ChainGetTipSetByHeight(ctx, to, head.Key())
+		if err != nil {
+			return err
+		}
+
+		collect := func(ts *types.TipSet) error {
+			first := ts.Blocks()[0]
+			pMsgs, err := api.ChainGetParentMessages(ctx, first.Cid())
+			if err != nil {
+				return xerrors.Errorf("failed to get parent messages: %w", err)
+			}
+			recpts, err := api.ChainGetParentReceipts(ctx, first.Cid())
+			if err != nil {
+				return xerrors.Errorf("failed to get receipts: %w", err)
+			}
+			if len(pMsgs) != len(recpts) {
+				return xerrors.Errorf("got %d parent messages but %d receipts", len(pMsgs), len(recpts))
+			}
+
+			getEvents := prefetchEvents(ctx, recpts, func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+				return api1.ChainGetEvents(ctx, root)
+			})
+
+			// only needed to decode EVM logs, so resolved once something matches
+			var getActor GetActorFunc
+			for i, recpt := range recpts {
+				if recpt.EventsRoot == nil {
+					continue
+				}
+				events, err := getEvents(ctx, *recpt.EventsRoot)
+				if err != nil {
+					return xerrors.Errorf("failed to get events of message %s: %w", pMsgs[i].Cid, err)
+				}
+				for idx, evt := range events {
+					if !filter.match(evt) {
+						continue
+					}
+					if getActor == nil {
+						if getActor, err = BlockReceiptActorFunc(ctx, api, first); err != nil {
+							return xerrors.Errorf("failed to load tipset state: %w", err)
+						}
+					}
+					rec := EXEventRecord{
+						Height:     ts.Height(),
+						TipSet:     ts.Key(),
+						MsgCid:     pMsgs[i].Cid,
+						EventIndex: idx,
+						EXEvent:    DecodeEvents(ctx, []types.Event{evt}, getActor)[0],
+					}
+					b, err := json.Marshal(rec)
+					if err != nil {
+						return err
+					}
+					afmt.Println(string(b))
+				}
+			}
+			return nil
+		}
+
+		if err := replayTipSets(ctx, api, from, last, collect); err != nil {
+			return err
+		}
+		if last.Height() >= from {
+			return collect(last)
+		}
+		return nil
+	},
+}
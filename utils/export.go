@@ -0,0 +1,490 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+)
+
+type exportBlock struct {
+	Height          abi.ChainEpoch
+	BlockCid        cid.Cid
+	Miner           string
+	Timestamp       uint64
+	WinCount        int64
+	ParentWeight    string
+	ParentBaseFee   string
+	ParentStateRoot cid.Cid
+	BlsMessages     int
+	SecpkMessages   int
+}
+
+func (b *exportBlock) header() []string {
+	return []string{"height", "block_cid", "miner", "timestamp", "win_count", "parent_weight", "parent_base_fee", "parent_state_root", "bls_messages", "secpk_messages"}
+}
+
+func (b *exportBlock) row() []string {
+	return []string{
+		b.Height.String(), b.BlockCid.String(), b.Miner, strconv.FormatUint(b.Timestamp, 10), strconv.FormatInt(b.WinCount, 10),
+		b.ParentWeight, b.ParentBaseFee, b.ParentStateRoot.String(), strconv.Itoa(b.BlsMessages), strconv.Itoa(b.SecpkMessages),
+	}
+}
+
+type exportMessage struct {
+	Height     abi.ChainEpoch
+	BlockCid   cid.Cid
+	Cid        cid.Cid
+	From       string
+	To         string
+	Nonce      uint64
+	Value      string
+	Method     abi.MethodNum
+	MethodName string
+	ParamJson  string
+	GasLimit   int64
+	GasFeeCap  string
+	GasPremium string
+	// outcome of the message, from the receipt carried by the next tipset
+	GasUsed int64
+	Status  string
+	Events  []EXEvent `json:",omitempty"`
+}
+
+func (m *exportMessage) header() []string {
+	return []string{
+		"height", "block_cid", "cid", "from", "to", "nonce", "value", "method", "method_name", "param_json", "gas_limit", "gas_fee_cap", "gas_premium",
+		"gas_used", "status", "events",
+	}
+}
+
+func (m *exportMessage) row() []string {
+	return []string{
+		m.Height.String(), m.BlockCid.String(), m.Cid.String(), m.From, m.To, strconv.FormatUint(m.Nonce, 10), m.Value,
+		m.Method.String(), m.MethodName, m.ParamJson, strconv.FormatInt(m.GasLimit, 10), m.GasFeeCap, m.GasPremium,
+		strconv.FormatInt(m.GasUsed, 10), m.Status, eventsColumn(m.Events),
+	}
+}
+
+// eventsColumn renders events as a JSON CSV column.
+func eventsColumn(events []EXEvent) string {
+	if len(events) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(events)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// exportReceipt is the outcome of a message. Height is the height of the
+// tipset carrying the receipt, which is the first non-null tipset after the
+// one including the message.
+type exportReceipt struct {
+	Height abi.ChainEpoch
+	EXPerMessage
+}
+
+func (r *exportReceipt) header() []string {
+	return []string{
+		"height", "cid", "method_name", "return_json", "gas_used", "status", "error_message", "events",
+		"base_fee", "base_fee_burn", "over_estimation_burn", "miner_penalty", "miner_tip", "refund", "total_cost",
+	}
+}
+
+func (r *exportReceipt) row() []string {
+	gas := make([]string, 6)
+	if r.GasCost != nil {
+		gas = []string{
+			r.BaseFeeBurn.String(), r.OverEstimationBurn.String(), r.MinerPenalty.String(), r.MinerTip.String(), r.Refund.String(), r.TotalCost.String(),
+		}
+	}
+	return append([]string{
+		r.Height.String(), r.CID.String(), r.MethodName, r.ReturnJson, strconv.FormatInt(r.GasUsed, 10), r.Status, r.ErrorMessage, eventsColumn(r.Events),
+		r.BaseFee.String(),
+	}, gas...)
+}
+
+type exportRow interface {
+	header() []string
+	row() []string
+}
+
+// exportFile appends rows of one kind to <dir>/<name>.<format>.
+type exportFile struct {
+	f   *os.File
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+// openExportFile opens an export file for appending. When resuming, offset is
+// the size recorded in the cursor and anything written after it is discarded;
+// a negative offset starts from an empty file.
+func openExportFile(dir, name, format string, header []string, offset int64) (*exportFile, error) {
+	path := filepath.Join(dir, name+"."+format)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if err := f.Truncate(offset); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	ef := &exportFile{f: f}
+	switch format {
+	case "csv":
+		ef.csv = csv.NewWriter(f)
+		if st.Size() == 0 {
+			if err := ef.csv.Write(header); err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+		}
+	case "ndjson":
+		ef.enc = json.NewEncoder(f)
+	default:
+		_ = f.Close()
+		return nil, xerrors.Errorf("unknown format %q", format)
+	}
+	return ef, nil
+}
+
+func (ef *exportFile) write(r exportRow) error {
+	if ef.csv != nil {
+		return ef.csv.Write(r.row())
+	}
+	return ef.enc.Encode(r)
+}
+
+func (ef *exportFile) flush() error {
+	if ef.csv != nil {
+		ef.csv.Flush()
+		if err := ef.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return ef.f.Sync()
+}
+
+func (ef *exportFile) Close() error {
+	if err := ef.flush(); err != nil {
+		_ = ef.f.Close()
+		return err
+	}
+	return ef.f.Close()
+}
+
+// exportCursor records the last fully written height and the size of every
+// export file at that point, so a resumed export can drop partially written
+// batches.
+type exportCursor struct {
+	Height  abi.ChainEpoch
+	Offsets map[string]int64
+}
+
+func readExportCursor(path string) (*exportCursor, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var c exportCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, xerrors.Errorf("parsing cursor %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+func writeExportCursor(path string, files map[string]*exportFile, h abi.ChainEpoch) error {
+	c := exportCursor{
+		Height:  h,
+		Offsets: make(map[string]int64, len(files)),
+	}
+	for name, ef := range files {
+		st, err := ef.f.Stat()
+		if err != nil {
+			return err
+		}
+		c.Offsets[name] = st.Size()
+	}
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+var ChainExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Export decoded blocks, messages and receipts over a height range",
+	Description: `Writes blocks, messages and receipts files into the output directory. Messages
+carry their gas used, status and events; the full outcome is in the receipts
+file, joined by cid. A receipt is carried by the first non-null tipset after
+the one including the message, so the chain head itself is not exported.
+
+The height of the last fully written tipset is kept in the cursor file, and an
+interrupted export picks up after it when run again with the same output.
+Without a cursor file existing export files are overwritten.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "from",
+			Usage:    "first height to export",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:  "to",
+			Usage: "last height to export (default: the height below the chain head)",
+			Value: -1,
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "directory to write the export files to",
+			Value: ".",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format: ndjson or csv",
+			Value: "ndjson",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of tipsets decoded in parallel",
+			Value: 8,
+		},
+		&cli.StringFlag{
+			Name:  "cursor",
+			Usage: "resume cursor file (default: <output>/export.cursor)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		api1, closer1, err := GetFullNodeAPIV1(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer1()
+		ctx := ReqContext(cctx)
+
+		format := cctx.String("format")
+		if format != "ndjson" && format != "csv" {
+			return xerrors.Errorf("unknown format %q", format)
+		}
+		concurrency := cctx.Int("concurrency")
+		if concurrency < 1 {
+			concurrency = 1
+		}
+
+		dir := cctx.String("output")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		cursorPath := cctx.String("cursor")
+		if cursorPath == "" {
+			cursorPath = filepath.Join(dir, "export.cursor")
+		}
+
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		from := abi.ChainEpoch(cctx.Int64("from"))
+		to := abi.ChainEpoch(cctx.Int64("to"))
+		// messages of the head have no receipts yet
+		if to < 0 || to >= head.Height() {
+			to = head.Height() - 1
+		}
+
+		cursor, err := readExportCursor(cursorPath)
+		if err != nil {
+			return err
+		}
+		offsets := map[string]int64{}
+		if cursor != nil {
+			// appending past the cursor would leave a hole in the files
+			if from > cursor.Height+1 {
+				return xerrors.Errorf("--from %d is after height %d following the cursor in %s; pass a new --output or --cursor to start over", from, cursor.Height+1, cursorPath)
+			}
+			log.Infof("resuming export after height %d", cursor.Height)
+			from = cursor.Height + 1
+			offsets = cursor.Offsets
+		}
+		if from > to {
+			afmt.Println("nothing to export")
+			return nil
+		}
+
+		files := make(map[string]*exportFile)
+		for name, hdr := range map[string][]string{
+			"blocks":   (*exportBlock)(nil).header(),
+			"messages": (*exportMessage)(nil).header(),
+			"receipts": (*exportReceipt)(nil).header(),
+		} {
+			offset, ok := offsets[name]
+			if !ok {
+				offset = -1
+			}
+			ef, err := openExportFile(dir, name, format, hdr, offset)
+			if err != nil {
+				return err
+			}
+			defer ef.Close() //nolint:errcheck
+			files[name] = ef
+		}
+
+		getEvents := func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+			return api1.ChainGetEvents(ctx, root)
+		}
+
+		toTs, err := api.ChainGetTipSetByHeight(ctx, to, head.Key())
+		if err != nil {
+			return err
+		}
+
+		for start := from; start <= to; start += abi.ChainEpoch(concurrency) {
+			end := start + abi.ChainEpoch(concurrency) - 1
+			if end > to {
+				end = to
+			}
+
+			// decode the batch in parallel, then write it out in height order
+			decoded := make([]*EXTipSet, end-start+1)
+			eg, ectx := errgroup.WithContext(ctx)
+			for h := start; h <= end; h++ {
+				h := h
+				eg.Go(func() error {
+					ts, err := api.ChainGetTipSetByHeight(ectx, h, toTs.Key())
+					if err != nil {
+						return xerrors.Errorf("get tipset at height %d: %w", h, err)
+					}
+					if ts.Height() != h {
+						// null round
+						return nil
+					}
+					rec, err := DecodeTipSet(ectx, api, ts, getEvents)
+					if err != nil {
+						return xerrors.Errorf("decoding tipset at height %d: %w", h, err)
+					}
+					decoded[h-start] = rec
+					return nil
+				})
+			}
+			if err := eg.Wait(); err != nil {
+				return err
+			}
+
+			for _, rec := range decoded {
+				if rec == nil {
+					continue
+				}
+				if err := writeExportTipSet(files, rec); err != nil {
+					return err
+				}
+			}
+			for _, ef := range files {
+				if err := ef.flush(); err != nil {
+					return err
+				}
+			}
+			if err := writeExportCursor(cursorPath, files, end); err != nil {
+				return err
+			}
+			log.Infof("exported heights %d-%d", start, end)
+		}
+
+		afmt.Printf("exported heights %d-%d to %s\n", from, to, dir)
+		return nil
+	},
+}
+
+func writeExportTipSet(files map[string]*exportFile, rec *EXTipSet) error {
+	for _, blk := range rec.Blocks {
+		bcid := blk.Cid()
+		eb := &exportBlock{
+			Height:          rec.Height,
+			BlockCid:        bcid,
+			Miner:           blk.Miner.String(),
+			Timestamp:       blk.Timestamp,
+			ParentWeight:    blk.ParentWeight.String(),
+			ParentBaseFee:   blk.ParentBaseFee.String(),
+			ParentStateRoot: blk.ParentStateRoot,
+			BlsMessages:     len(blk.BlsMessages),
+			SecpkMessages:   len(blk.SecpkMessages),
+		}
+		if blk.ElectionProof != nil {
+			eb.WinCount = blk.ElectionProof.WinCount
+		}
+		if err := files["blocks"].write(eb); err != nil {
+			return err
+		}
+
+		writeMsg := func(c cid.Cid, m *types.Message, methodName, paramJson string, gasUsed int64, status string, events []EXEvent) error {
+			return files["messages"].write(&exportMessage{
+				Height:     rec.Height,
+				BlockCid:   bcid,
+				Cid:        c,
+				From:       m.From.String(),
+				To:         m.To.String(),
+				Nonce:      m.Nonce,
+				Value:      m.Value.String(),
+				Method:     m.Method,
+				MethodName: methodName,
+				ParamJson:  paramJson,
+				GasLimit:   m.GasLimit,
+				GasFeeCap:  m.GasFeeCap.String(),
+				GasPremium: m.GasPremium.String(),
+				GasUsed:    gasUsed,
+				Status:     status,
+				Events:     events,
+			})
+		}
+		for _, m := range blk.BlsMessages {
+			if err := writeMsg(m.Cid(), &m.Message, m.MethodName, m.ParamJson, m.GasUsed, m.Status, m.Events); err != nil {
+				return err
+			}
+		}
+		for _, m := range blk.SecpkMessages {
+			if err := writeMsg(m.Cid(), m.VMMessage(), m.MethodName, m.ParamJson, m.GasUsed, m.Status, m.Events); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range rec.ParentReceipts {
+		if err := files["receipts"].write(&exportReceipt{Height: rec.Height, EXPerMessage: *r}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/store"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+var ChainFollowCmd = &cli.Command{
+	Name:  "follow",
+	Usage: "Stream decoded tipsets as NDJSON while following the chain head",
+	Description: `Prints one "apply" record per tipset added to the chain and one "revert" record
+per tipset removed by a reorg. Null rounds produce no records.
+
+To resume after a restart pass --from-height with the height following the
+last applied record; tipsets up to the current head are replayed first.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "from-height",
+			Usage: "replay tipsets from this height before following the head",
+			Value: -1,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		api1, closer1, err := GetFullNodeAPIV1(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer1()
+		ctx := ReqContext(cctx)
+
+		emit := func(rec *EXTipSet) error {
+			out, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			afmt.Println(string(out))
+			return nil
+		}
+
+		apply := func(ts *types.TipSet) error {
+			rec, err := DecodeTipSet(ctx, api, ts, func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+				return api1.ChainGetEvents(ctx, root)
+			})
+			if err != nil {
+				return xerrors.Errorf("decoding tipset at height %d: %w", ts.Height(), err)
+			}
+			rec.Type = store.HCApply
+			return emit(rec)
+		}
+
+		revert := func(ts *types.TipSet) error {
+			return emit(&EXTipSet{
+				Type:   store.HCRevert,
+				Height: ts.Height(),
+				TipSet: ts.Key(),
+			})
+		}
+
+		notifs, err := api.ChainNotify(ctx)
+		if err != nil {
+			return err
+		}
+
+		fromHeight := abi.ChainEpoch(cctx.Int64("from-height"))
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case changes, ok := <-notifs:
+				if !ok {
+					return xerrors.Errorf("chain notify channel closed")
+				}
+				for _, hc := range changes {
+					switch hc.Type {
+					case store.HCCurrent:
+						if fromHeight >= 0 {
+							if err := replayTipSets(ctx, api, fromHeight, hc.Val, apply); err != nil {
+								return err
+							}
+						}
+						if err := apply(hc.Val); err != nil {
+							return err
+						}
+					case store.HCApply:
+						if err := apply(hc.Val); err != nil {
+							return err
+						}
+					case store.HCRevert:
+						if err := revert(hc.Val); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	},
+}
+
+// replayTipSets calls cb for every non-null tipset from height up to, but not
+// including, head.
+func replayTipSets(ctx context.Context, api v0api.FullNode, from abi.ChainEpoch, head *types.TipSet, cb func(ts *types.TipSet) error) error {
+	for h := from; h < head.Height(); h++ {
+		ts, err := api.ChainGetTipSetByHeight(ctx, h, head.Key())
+		if err != nil {
+			return xerrors.Errorf("get tipset at height %d: %w", h, err)
+		}
+		if ts.Height() != h {
+			// null round
+			continue
+		}
+		if err := cb(ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v1api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+// EXMessageLookup is a message located on chain together with its decoded
+// outcome. The message is included in InclusionTipSet and executed on top of
+// it; its receipt is carried by ExecutionTipSet, the next non-null tipset.
+type EXMessageLookup struct {
+	CID             cid.Cid
+	EthTxHash       *ethtypes.EthHash `json:",omitempty"`
+	Message         *types.Message
+	MethodName      string
+	ParamJson       string
+	Receipt         *EXPerMessage
+	InclusionHeight abi.ChainEpoch
+	InclusionTipSet types.TipSetKey
+	InclusionBlocks []cid.Cid
+	ExecutionHeight abi.ChainEpoch
+	ExecutionTipSet types.TipSetKey
+	// epochs the head is past the inclusion tipset, null rounds included
+	ConfirmationEpochs int64
+	Eth                *EXEthTransaction `json:",omitempty"`
+}
+
+var ChainGetMessageCmd = &cli.Command{
+	Name:      "get-message",
+	Aliases:   []string{"getmessage"},
+	Usage:     "Find a message by cid or Ethereum transaction hash and print its decoded outcome",
+	ArgsUsage: "[messageCid|0xTxHash]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "eth",
+			Usage: "also render the message as an Ethereum transaction with its receipt",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		api1, closer1, err := GetFullNodeAPIV1(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer1()
+		ctx := ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("must pass cid or transaction hash of message")
+		}
+
+		mcid, err := resolveMessageCid(ctx, api1, cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		lookup, err := api.StateSearchMsg(ctx, mcid)
+		if err != nil {
+			return xerrors.Errorf("search message failed: %w", err)
+		}
+		if lookup == nil {
+			return xerrors.Errorf("message %s not found on chain", mcid)
+		}
+
+		msg, err := api.ChainGetMessage(ctx, lookup.Message)
+		if err != nil {
+			return xerrors.Errorf("get message failed: %w", err)
+		}
+
+		execTs, err := api.ChainGetTipSet(ctx, lookup.TipSet)
+		if err != nil {
+			return xerrors.Errorf("get tipset failed: %w", err)
+		}
+		inclTs, err := api.ChainGetTipSet(ctx, execTs.Parents())
+		if err != nil {
+			return xerrors.Errorf("get tipset failed: %w", err)
+		}
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+
+		store := apiActorStore(ctx, api)
+		getActor, err := MakeGetActorFunc(ctx, store, execTs, inclTs)
+		if err != nil {
+			return err
+		}
+		getActor = CacheActorFunc(getActor)
+		preActor, err := MakeGetActorFunc(ctx, store, inclTs, inclTs)
+		if err != nil {
+			return err
+		}
+		getTxn := MakeGetMsigTxnFunc(store, CacheActorFunc(preActor))
+
+		out := &EXMessageLookup{
+			CID:                lookup.Message,
+			Message:            msg,
+			InclusionHeight:    inclTs.Height(),
+			InclusionTipSet:    inclTs.Key(),
+			ExecutionHeight:    execTs.Height(),
+			ExecutionTipSet:    execTs.Key(),
+			ConfirmationEpochs: int64(head.Height() - inclTs.Height()),
+		}
+		if hash, err := api1.EthGetTransactionHashByCid(ctx, lookup.Message); err == nil && hash != nil {
+			out.EthTxHash = hash
+		}
+		out.MethodName, out.ParamJson = DecodeMessageParams(ctx, msg, getActor, getTxn)
+
+		// messages are executed with the base fee recorded in the inclusion tipset
+		baseFee := inclTs.Blocks()[0].ParentBaseFee
+		out.Receipt = DecodeReceipt(ctx, lapi.Message{Cid: lookup.Message, Message: msg}, &lookup.Receipt, baseFee, getActor, getTxn,
+			func(ctx context.Context, root cid.Cid) ([]types.Event, error) {
+				return api1.ChainGetEvents(ctx, root)
+			})
+
+		for _, blk := range inclTs.Blocks() {
+			msgs, err := api.ChainGetBlockMessages(ctx, blk.Cid())
+			if err != nil {
+				return xerrors.Errorf("failed to get messages: %w", err)
+			}
+			if blockIncludes(msgs, lookup.Message) {
+				out.InclusionBlocks = append(out.InclusionBlocks, blk.Cid())
+			}
+		}
+
+		if cctx.Bool("eth") {
+			if out.Eth, err = EthTransactionView(ctx, api1, lookup.Message); err != nil {
+				return err
+			}
+		}
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		afmt.Println(string(b))
+		return nil
+	},
+}
+
+// resolveMessageCid parses a message cid, or maps an Ethereum transaction
+// hash to the cid of the message carrying it.
+func resolveMessageCid(ctx context.Context, api v1api.FullNode, s string) (cid.Cid, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return cid.Decode(s)
+	}
+
+	hash, err := ethtypes.ParseEthHash(s)
+	if err != nil {
+		return cid.Undef, err
+	}
+	mcid, err := api.EthGetMessageCidByTransactionHash(ctx, &hash)
+	if err != nil {
+		return cid.Undef, xerrors.Errorf("lookup transaction hash failed: %w", err)
+	}
+	if mcid == nil {
+		return cid.Undef, xerrors.Errorf("transaction %s not found", hash)
+	}
+	return *mcid, nil
+}
+
+func blockIncludes(msgs *lapi.BlockMessages, mcid cid.Cid) bool {
+	for _, c := range msgs.Cids {
+		if c == mcid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+// number of blocks the suggested gas premium aims to get a message into
+const mpoolInclusionBlocks = 10
+
+// EXMempoolMessage is a pending message decoded like the messages of
+// get-block. Message.BaseFee is the base fee of the next tipset, which a
+// message can only be included under if its fee cap covers it.
+// EffectivePremium is what the miner would get per unit of gas at that base
+// fee, and EstimatedFee the most the message would cost the sender.
+type EXMempoolMessage struct {
+	Message          *EXSignedMessage
+	EffectivePremium abi.TokenAmount
+	EstimatedFee     abi.TokenAmount
+	Warnings         []string `json:",omitempty"`
+}
+
+// EXNonceGap is a range of nonces missing from a sender's pending messages.
+// Nothing after a gap can be included until it is filled.
+type EXNonceGap struct {
+	From uint64
+	To   uint64
+}
+
+// EXMempoolSender groups the pending messages of a sender in nonce order.
+type EXMempoolSender struct {
+	Address   address.Address
+	Nonce     uint64
+	Pending   int
+	NonceGaps []EXNonceGap `json:",omitempty"`
+	Messages  []*EXMempoolMessage
+}
+
+// EXMempool is the pending message pool as seen from the chain head.
+type EXMempool struct {
+	Height           abi.ChainEpoch
+	BaseFee          abi.TokenAmount
+	SuggestedPremium abi.TokenAmount
+	Senders          []*EXMempoolSender
+}
+
+// mpoolSender checks the pending messages of one sender, in nonce order,
+// against its nonce after the head tipset and the next base fee. A message
+// that can't be included blocks every message after it.
+func mpoolSender(addr address.Address, nonce uint64, msgs []*EXSignedMessage, baseFee, suggested abi.TokenAmount) *EXMempoolSender {
+	out := &EXMempoolSender{
+		Address:  addr,
+		Nonce:    nonce,
+		Pending:  len(msgs),
+		Messages: make([]*EXMempoolMessage, 0, len(msgs)),
+	}
+
+	next := nonce
+	var blocked string
+	for _, sm := range msgs {
+		m := &sm.Message
+		em := &EXMempoolMessage{
+			Message:          sm,
+			EffectivePremium: big.Min(m.GasPremium, big.Max(big.Sub(m.GasFeeCap, baseFee), big.Zero())),
+			EstimatedFee:     big.Mul(big.Min(m.GasFeeCap, big.Add(baseFee, m.GasPremium)), big.NewInt(m.GasLimit)),
+		}
+		warn := func(format string, args ...interface{}) {
+			em.Warnings = append(em.Warnings, fmt.Sprintf(format, args...))
+		}
+
+		switch {
+		case m.Nonce < next:
+			warn("nonce %d is already used", m.Nonce)
+			out.Messages = append(out.Messages, em)
+			continue
+		case m.Nonce > next:
+			out.NonceGaps = append(out.NonceGaps, EXNonceGap{From: next, To: m.Nonce - 1})
+			warn("nonces %d-%d are missing before this message", next, m.Nonce-1)
+			if blocked == "" {
+				blocked = fmt.Sprintf("nonce gap before nonce %d", m.Nonce)
+			}
+		}
+		next = m.Nonce + 1
+
+		if blocked != "" {
+			warn("stuck behind %s", blocked)
+		}
+		if m.GasFeeCap.LessThan(baseFee) {
+			warn("fee cap %s is below the base fee %s", m.GasFeeCap, baseFee)
+			if blocked == "" {
+				blocked = fmt.Sprintf("nonce %d whose fee cap is below the base fee", m.Nonce)
+			}
+		} else if em.EffectivePremium.LessThan(suggested) {
+			warn("premium %s is below the suggested %s", em.EffectivePremium, suggested)
+			if blocked == "" {
+				blocked = fmt.Sprintf("nonce %d whose premium is low", m.Nonce)
+			}
+		}
+		out.Messages = append(out.Messages, em)
+	}
+	return out
+}
+
+// headNonces returns the nonce of every sender of the head tipset's messages
+// once they are executed, keyed by ID address. The head's state root is the
+// state before its own messages, so the nonce read there is advanced over the
+// messages the VM would apply, in tipset order.
+func headNonces(ctx context.Context, api v0api.FullNode, head *types.TipSet, getActor GetActorFunc) (map[address.Address]uint64, error) {
+	msgs, err := api.ChainGetMessagesInTipset(ctx, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("get head messages failed: %w", err)
+	}
+
+	ids := make(map[address.Address]address.Address)
+	out := make(map[address.Address]uint64)
+	for _, m := range msgs {
+		from, ok := ids[m.Message.From]
+		if !ok {
+			id, err := api.StateLookupID(ctx, m.Message.From, head.Key())
+			if err != nil {
+				id = address.Undef
+			}
+			ids[m.Message.From], from = id, id
+		}
+		if from == address.Undef {
+			continue
+		}
+		nonce, ok := out[from]
+		if !ok {
+			if act, ok := getActor(ctx, from); ok {
+				nonce = act.Nonce
+			}
+		}
+		// messages with an out of sequence nonce are skipped by the VM
+		if m.Message.Nonce == nonce {
+			nonce++
+		}
+		out[from] = nonce
+	}
+	return out, nil
+}
+
+// addrMatcher matches addresses against one, comparing ID addresses where
+// both resolve.
+type addrMatcher struct {
+	api  v0api.FullNode
+	tsk  types.TipSetKey
+	want address.Address
+	ids  map[address.Address]address.Address
+}
+
+func newAddrMatcher(ctx context.Context, api v0api.FullNode, tsk types.TipSetKey, s string) (*addrMatcher, error) {
+	if s == "" {
+		return nil, nil
+	}
+	addr, err := address.NewFromString(s)
+	if err != nil {
+		return nil, err
+	}
+	m := &addrMatcher{api: api, tsk: tsk, want: addr, ids: make(map[address.Address]address.Address)}
+	m.want = m.id(ctx, addr)
+	return m, nil
+}
+
+func (m *addrMatcher) id(ctx context.Context, addr address.Address) address.Address {
+	if addr.Protocol() == address.ID {
+		return addr
+	}
+	if id, ok := m.ids[addr]; ok {
+		return id
+	}
+	id, err := m.api.StateLookupID(ctx, addr, m.tsk)
+	if err != nil {
+		// not on chain yet, compare as is
+		id = addr
+	}
+	m.ids[addr] = id
+	return id
+}
+
+func (m *addrMatcher) match(ctx context.Context, addr address.Address) bool {
+	return m == nil || m.id(ctx, addr) == m.want
+}
+
+var ChainMpoolCmd = &cli.Command{
+	Name:  "mpool",
+	Usage: "List pending messages with nonce gaps, fee estimates and stuck-message warnings",
+	Description: `Lists the node's pending messages by sender in nonce order, decoded like the
+messages of get-block, against the base fee of the next tipset. A message is
+flagged as stuck when a nonce is missing before it, when its fee cap is below
+the base fee, when its premium is below the one the node suggests for
+inclusion within 10 blocks, or when an earlier message of the sender is stuck.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "only messages sent by this address",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "only messages sent to this address",
+		},
+		&cli.StringFlag{
+			Name:  "method",
+			Usage: "only messages calling this method, e.g. SubmitWindowedPoSt",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		fromMatch, err := newAddrMatcher(ctx, api, head.Key(), cctx.String("from"))
+		if err != nil {
+			return err
+		}
+		toMatch, err := newAddrMatcher(ctx, api, head.Key(), cctx.String("to"))
+		if err != nil {
+			return err
+		}
+		method := cctx.String("method")
+
+		next, err := baseFeeEpoch(ctx, api, head)
+		if err != nil {
+			return err
+		}
+		suggested, err := api.GasEstimateGasPremium(ctx, mpoolInclusionBlocks, address.Undef, 0, head.Key())
+		if err != nil {
+			return xerrors.Errorf("estimate gas premium failed: %w", err)
+		}
+
+		pending, err := api.MpoolPending(ctx, head.Key())
+		if err != nil {
+			return xerrors.Errorf("get pending messages failed: %w", err)
+		}
+
+		store := apiActorStore(ctx, api)
+		getActor, err := MakeGetActorFunc(ctx, store, head, head)
+		if err != nil {
+			return err
+		}
+		getActor = CacheActorFunc(getActor)
+		getTxn := MakeGetMsigTxnFunc(store, getActor)
+		nonces, err := headNonces(ctx, api, head, getActor)
+		if err != nil {
+			return err
+		}
+
+		// nonce gaps need every pending message of a sender, filters only
+		// apply to what is printed
+		bySender := make(map[address.Address][]*EXSignedMessage)
+		for _, sm := range pending {
+			exmsg := new(EXSignedMessage)
+			exmsg.SignedMessage = *sm
+			exmsg.MethodName, exmsg.ParamJson = DecodeMessageParams(ctx, sm.VMMessage(), getActor, getTxn)
+			exmsg.BaseFee = next.NextBaseFee
+			bySender[sm.Message.From] = append(bySender[sm.Message.From], exmsg)
+		}
+
+		out := &EXMempool{
+			Height:           head.Height(),
+			BaseFee:          next.NextBaseFee,
+			SuggestedPremium: suggested,
+			Senders:          make([]*EXMempoolSender, 0),
+		}
+		for from, msgs := range bySender {
+			if !fromMatch.match(ctx, from) {
+				continue
+			}
+			sort.Slice(msgs, func(i, j int) bool { return msgs[i].Message.Nonce < msgs[j].Message.Nonce })
+
+			var nonce uint64
+			if act, ok := getActor(ctx, from); ok {
+				nonce = act.Nonce
+			}
+			if id, err := api.StateLookupID(ctx, from, head.Key()); err == nil {
+				if n, ok := nonces[id]; ok {
+					nonce = n
+				}
+			}
+			sender := mpoolSender(from, nonce, msgs, next.NextBaseFee, suggested)
+
+			shown := sender.Messages[:0]
+			for _, em := range sender.Messages {
+				if !toMatch.match(ctx, em.Message.Message.To) {
+					continue
+				}
+				if method != "" && em.Message.MethodName != method {
+					continue
+				}
+				shown = append(shown, em)
+			}
+			if len(shown) == 0 {
+				continue
+			}
+			sender.Messages = shown
+			out.Senders = append(out.Senders, sender)
+		}
+		sort.Slice(out.Senders, func(i, j int) bool { return out.Senders[i].Address.String() < out.Senders[j].Address.String() })
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		afmt.Println(string(b))
+		return nil
+	},
+}
@@ -0,0 +1,388 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	msig14 "github.com/filecoin-project/go-state-types/builtin/v14/multisig"
+	"github.com/filecoin-project/go-state-types/exitcode"
+	"github.com/filecoin-project/go-state-types/manifest"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/multisig"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/ethtypes"
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+// GetMsigTxnFunc resolves a pending multisig transaction in the state a
+// message is executed on. Approvals and cancellations only carry the
+// transaction id, the proposed call lives in the multisig state.
+type GetMsigTxnFunc = func(ctx context.Context, msig address.Address, id int64) (*multisig.Transaction, bool)
+
+// MakeGetMsigTxnFunc returns a pending transaction lookup over the multisigs
+// resolved by getActor, which should resolve actors in the pre-execution
+// state.
+func MakeGetMsigTxnFunc(store adt.Store, getActor GetActorFunc) GetMsigTxnFunc {
+	return func(ctx context.Context, msig address.Address, id int64) (*multisig.Transaction, bool) {
+		act, ok := getActor(ctx, msig)
+		if !ok {
+			return nil, false
+		}
+		st, err := multisig.Load(store, act)
+		if err != nil {
+			return nil, false
+		}
+
+		var found *multisig.Transaction
+		err = st.ForEachPendingTxn(func(txid int64, txn multisig.Transaction) error {
+			if txid == id {
+				found = &txn
+			}
+			return nil
+		})
+		if err != nil || found == nil {
+			return nil, false
+		}
+		return found, true
+	}
+}
+
+// ExMsigCall is a decoded multisig Propose, Approve or Cancel. The proposed
+// call comes from the params of a proposal, or from the pending transaction
+// for approvals and cancellations, and is decoded against its own receiver.
+type ExMsigCall struct {
+	TxnID        *int64            `json:",omitempty"`
+	ProposalHash string            `json:",omitempty"`
+	To           *address.Address  `json:",omitempty"`
+	Value        *abi.TokenAmount  `json:",omitempty"`
+	Method       *abi.MethodNum    `json:",omitempty"`
+	MethodName   string            `json:",omitempty"`
+	Params       json.RawMessage   `json:",omitempty"`
+	Approved     []address.Address `json:",omitempty"`
+}
+
+// ExMsigReturn is the decoded return of a multisig Propose or Approve. Return
+// is only set when the proposed call was executed.
+type ExMsigReturn struct {
+	TxnID      *int64 `json:",omitempty"`
+	Applied    bool
+	Code       exitcode.ExitCode
+	MethodName string          `json:",omitempty"`
+	Return     json.RawMessage `json:",omitempty"`
+}
+
+func isMsigCall(code cid.Cid, method abi.MethodNum) bool {
+	if _, family, err := ActorNameAndFamilyFromCode(code); err != nil || family != manifest.MultisigKey {
+		return false
+	}
+	switch method {
+	case builtin.MethodsMultisig.Propose, builtin.MethodsMultisig.Approve, builtin.MethodsMultisig.Cancel:
+		return true
+	}
+	return false
+}
+
+// maxMsigDepth bounds how many multisig calls are decoded within each other.
+// A transaction can approve itself, or one of another multisig approving it
+// back, which would otherwise be decoded forever.
+const maxMsigDepth = 4
+
+type msigDepthKey struct{}
+
+// nestedMsigContext returns the context to decode the call proposed by a
+// multisig message with, or false once calls are nested too deeply.
+func nestedMsigContext(ctx context.Context) (context.Context, bool) {
+	depth, _ := ctx.Value(msigDepthKey{}).(int)
+	if depth >= maxMsigDepth {
+		return ctx, false
+	}
+	return context.WithValue(ctx, msigDepthKey{}, depth+1), true
+}
+
+// proposedCall returns the call a multisig message proposes, approves or
+// cancels, if it can be resolved.
+func proposedCall(ctx context.Context, msg *types.Message, getTxn GetMsigTxnFunc) (*ExMsigCall, *types.Message, error) {
+	if msg.Method == builtin.MethodsMultisig.Propose {
+		var p msig14.ProposeParams
+		if err := p.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+			return nil, nil, err
+		}
+		inner := &types.Message{To: p.To, Value: p.Value, Method: p.Method, Params: p.Params}
+		return &ExMsigCall{To: &p.To, Value: &p.Value, Method: &p.Method}, inner, nil
+	}
+
+	var p msig14.TxnIDParams
+	if err := p.UnmarshalCBOR(bytes.NewReader(msg.Params)); err != nil {
+		return nil, nil, err
+	}
+	id := int64(p.ID)
+	call := &ExMsigCall{TxnID: &id}
+	if len(p.ProposalHash) > 0 {
+		call.ProposalHash = "0x" + hex.EncodeToString(p.ProposalHash)
+	}
+	if getTxn == nil {
+		return call, nil, nil
+	}
+	txn, ok := getTxn(ctx, msg.To, id)
+	if !ok {
+		return call, nil, nil
+	}
+	call.To, call.Value, call.Method, call.Approved = &txn.To, &txn.Value, &txn.Method, txn.Approved
+	return call, &types.Message{To: txn.To, Value: txn.Value, Method: txn.Method, Params: txn.Params}, nil
+}
+
+// DecodeMsigParams decodes the params of a multisig Propose, Approve or Cancel
+// including the proposed call.
+func DecodeMsigParams(ctx context.Context, msg *types.Message, getActor GetActorFunc, getTxn GetMsigTxnFunc) (string, error) {
+	call, inner, err := proposedCall(ctx, msg, getTxn)
+	if err != nil {
+		return "", err
+	}
+	if inner != nil {
+		var params string
+		if ictx, ok := nestedMsigContext(ctx); ok {
+			call.MethodName, params = DecodeMessageParams(ictx, inner, getActor, getTxn)
+		}
+		call.Params = rawJSONOrHex(params, inner.Params)
+	}
+
+	b, err := json.Marshal(call)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// DecodeMsigReturn decodes the return of a multisig Propose or Approve,
+// including the return of the proposed call when it was executed.
+func DecodeMsigReturn(ctx context.Context, msg *types.Message, ret []byte, getActor GetActorFunc, getTxn GetMsigTxnFunc) (string, error) {
+	var out ExMsigReturn
+	var innerRet []byte
+	switch msg.Method {
+	case builtin.MethodsMultisig.Propose:
+		var r msig14.ProposeReturn
+		if err := r.UnmarshalCBOR(bytes.NewReader(ret)); err != nil {
+			return "", err
+		}
+		id := int64(r.TxnID)
+		out.TxnID, out.Applied, out.Code, innerRet = &id, r.Applied, r.Code, r.Ret
+	case builtin.MethodsMultisig.Approve:
+		var r msig14.ApproveReturn
+		if err := r.UnmarshalCBOR(bytes.NewReader(ret)); err != nil {
+			return "", err
+		}
+		out.Applied, out.Code, innerRet = r.Applied, r.Code, r.Ret
+	default:
+		return "", nil
+	}
+
+	if out.Applied {
+		if _, inner, err := proposedCall(ctx, msg, getTxn); err == nil && inner != nil {
+			var r string
+			if ictx, ok := nestedMsigContext(ctx); ok {
+				out.MethodName, r = DecodeReturn(ictx, inner, innerRet, getActor, getTxn)
+			}
+			out.Return = rawJSONOrHex(r, innerRet)
+		} else if len(innerRet) > 0 {
+			out.Return = rawJSONOrHex("", innerRet)
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// rawJSONOrHex embeds decoded JSON, falling back to the raw bytes as a hex
+// string when decoding failed.
+func rawJSONOrHex(decoded string, raw []byte) json.RawMessage {
+	if decoded != "" && json.Valid([]byte(decoded)) {
+		return json.RawMessage(decoded)
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal("0x" + hex.EncodeToString(raw))
+	return b
+}
+
+type EXMsigSigner struct {
+	ID         address.Address
+	Robust     *address.Address `json:",omitempty"`
+	EthAddress string           `json:",omitempty"`
+}
+
+type EXMsigTransaction struct {
+	ID         int64
+	To         address.Address
+	Value      abi.TokenAmount
+	Method     abi.MethodNum
+	MethodName string
+	Params     json.RawMessage `json:",omitempty"`
+	Approved   []address.Address
+}
+
+type EXMsigState struct {
+	Address             address.Address
+	ID                  address.Address
+	StateHeight         abi.ChainEpoch
+	Balance             abi.TokenAmount
+	AvailableBalance    abi.TokenAmount
+	LockedBalance       abi.TokenAmount
+	InitialBalance      abi.TokenAmount
+	StartEpoch          abi.ChainEpoch
+	UnlockDuration      abi.ChainEpoch
+	Threshold           uint64
+	Signers             []EXMsigSigner
+	PendingTransactions []EXMsigTransaction
+}
+
+var MsigExCmd = &cli.Command{
+	Name:  "msig",
+	Usage: "Interact with multisig actors",
+	Subcommands: []*cli.Command{
+		MsigInspectCmd,
+	},
+}
+
+var MsigInspectCmd = &cli.Command{
+	Name:      "inspect",
+	Usage:     "Print a multisig's signers, vesting schedule and decoded pending transactions",
+	ArgsUsage: "[multisig address]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "tipset",
+			Usage: "inspect the multisig at this tipset, given as comma separated block cids or @height (default: chain head)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("must specify multisig to inspect")
+		}
+
+		maddr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		ts, err := lcli.LoadTipSet(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
+
+		mact, err := api.StateGetActor(ctx, maddr, ts.Key())
+		if err != nil {
+			return err
+		}
+		idAddr, err := api.StateLookupID(ctx, maddr, ts.Key())
+		if err != nil {
+			return err
+		}
+
+		store := apiActorStore(ctx, api)
+		mst, err := multisig.Load(store, mact)
+		if err != nil {
+			return err
+		}
+
+		out := EXMsigState{
+			Address:     maddr,
+			ID:          idAddr,
+			StateHeight: ts.Height(),
+			Balance:     mact.Balance,
+		}
+		if out.LockedBalance, err = mst.LockedBalance(ts.Height()); err != nil {
+			return err
+		}
+		out.AvailableBalance = big.Max(big.Sub(mact.Balance, out.LockedBalance), big.Zero())
+		if out.InitialBalance, err = mst.InitialBalance(); err != nil {
+			return err
+		}
+		if out.StartEpoch, err = mst.StartEpoch(); err != nil {
+			return err
+		}
+		if out.UnlockDuration, err = mst.UnlockDuration(); err != nil {
+			return err
+		}
+		if out.Threshold, err = mst.Threshold(); err != nil {
+			return err
+		}
+
+		signers, err := mst.Signers()
+		if err != nil {
+			return err
+		}
+		for _, s := range signers {
+			signer := EXMsigSigner{ID: s}
+			if robust, err := api.StateAccountKey(ctx, s, ts.Key()); err == nil {
+				signer.Robust = &robust
+			}
+			if signer.Robust != nil && signer.Robust.Protocol() == address.Delegated {
+				if ea, err := ethtypes.EthAddressFromFilecoinAddress(*signer.Robust); err == nil {
+					signer.EthAddress = ea.String()
+				}
+			} else if ea, err := ethtypes.EthAddressFromFilecoinAddress(s); err == nil {
+				signer.EthAddress = ea.String()
+			}
+			out.Signers = append(out.Signers, signer)
+		}
+
+		// pending transactions are executed on top of the current state
+		getActor, err := MakeGetActorFunc(ctx, store, ts, ts)
+		if err != nil {
+			return err
+		}
+		getActor = CacheActorFunc(getActor)
+		getTxn := MakeGetMsigTxnFunc(store, getActor)
+
+		err = mst.ForEachPendingTxn(func(id int64, txn multisig.Transaction) error {
+			inner := &types.Message{To: txn.To, Value: txn.Value, Method: txn.Method, Params: txn.Params}
+			methodName, params := DecodeMessageParams(ctx, inner, getActor, getTxn)
+			out.PendingTransactions = append(out.PendingTransactions, EXMsigTransaction{
+				ID:         id,
+				To:         txn.To,
+				Value:      txn.Value,
+				Method:     txn.Method,
+				MethodName: methodName,
+				Params:     rawJSONOrHex(params, txn.Params),
+				Approved:   txn.Approved,
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(out.PendingTransactions, func(i, j int) bool {
+			return out.PendingTransactions[i].ID < out.PendingTransactions[j].ID
+		})
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		afmt.Println(string(b))
+		return nil
+	},
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/filecoin-project/lotus/chain/consensus"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/filecoin-project/go-bitfield"
@@ -136,11 +137,91 @@ type MessageParamsReturn struct {
 }
 
 type MessageTrace struct {
-	Message   *types.Message
-	Receipt   *types.MessageReceipt
-	Error     string
-	Duration  time.Duration
-	GasCharge []*types.GasTrace
+	Message    *types.Message
+	Receipt    *types.MessageReceipt
+	MethodName string
+	ParamJson  string
+	ReturnJson string
+	Error      string
+	Duration   time.Duration
+	GasCharge  []*types.GasTrace
+	Subcalls   []*MessageTrace
+}
+
+// NewMessageTrace converts an execution trace into a MessageTrace tree, decoding
+// the params and return of every call. getCode is consulted for the callee code
+// when the trace doesn't carry the invoked actor.
+func NewMessageTrace(ctx context.Context, et types.ExecutionTrace, getCode GetActorCodeFunc) *MessageTrace {
+	mt := &MessageTrace{
+		Message: &types.Message{
+			From:     et.Msg.From,
+			To:       et.Msg.To,
+			Value:    et.Msg.Value,
+			Method:   et.Msg.Method,
+			Params:   et.Msg.Params,
+			GasLimit: int64(et.Msg.GasLimit),
+		},
+		Receipt: &types.MessageReceipt{
+			ExitCode: et.MsgRct.ExitCode,
+			Return:   et.MsgRct.Return,
+			GasUsed:  traceGasUsed(et),
+		},
+		GasCharge: et.GasCharges,
+	}
+	if et.MsgRct.ExitCode.IsError() {
+		mt.Error = et.MsgRct.ExitCode.Error()
+	}
+
+	code := cid.Undef
+	if et.InvokedActor != nil {
+		code = et.InvokedActor.State.Code
+	} else if c, ok := getCode(ctx, et.Msg.To); ok {
+		code = c
+	}
+
+	// params that fail to decode still leave us the method name
+	method, params, _ := MethodAndParamsForMessage(mt.Message, code)
+	if method == "" {
+		method = "Unknown"
+	}
+	mt.MethodName = method
+	mt.ParamJson = params
+	if code.Defined() && et.Msg.Method != 0 {
+		if ret, _, err := ParseReturn(et.MsgRct.Return, et.Msg.Method, code); err == nil {
+			mt.ReturnJson = ret
+		}
+	}
+	if isEamCreate(code, et.Msg.Method) {
+		if p, err := DecodeEamCreateParams(et.Msg.Method, et.Msg.Params); err == nil {
+			mt.ParamJson = p
+		}
+		if et.MsgRct.ExitCode.IsSuccess() {
+			if ret, err := DecodeEamCreateReturn(et.MsgRct.Return); err == nil {
+				mt.ReturnJson = ret
+			}
+		}
+	} else if method == "InvokeContract" {
+		var act *types.Actor
+		if et.InvokedActor != nil {
+			act = &et.InvokedActor.State
+		}
+		mt.ParamJson = DecodeInvokeContractParams(et.Msg.To, act, et.Msg.Params)
+		mt.ReturnJson = DecodeInvokeContractReturn(et.Msg.To, act, et.Msg.Params, et.MsgRct.Return)
+	}
+
+	for _, sc := range et.Subcalls {
+		mt.Subcalls = append(mt.Subcalls, NewMessageTrace(ctx, sc, getCode))
+	}
+	return mt
+}
+
+// traceGasUsed sums the gas charged to a call, including its subcalls.
+func traceGasUsed(et types.ExecutionTrace) int64 {
+	gas := et.SumGas().TotalGas
+	for _, sc := range et.Subcalls {
+		gas += traceGasUsed(sc)
+	}
+	return gas
 }
 
 func ActorNameAndFamilyFromCode(c cid.Cid) (name string, family string, err error) {
@@ -161,8 +242,24 @@ func ActorNameAndFamilyFromCode(c cid.Cid) (name string, family string, err erro
 	return
 }
 
+// GetActorCodeFunc resolves the code of the actor at an address in some state.
+type GetActorCodeFunc = func(ctx context.Context, a address.Address) (cid.Cid, bool)
+
+// GetActorFunc resolves the actor at an address in some state.
+type GetActorFunc = func(ctx context.Context, a address.Address) (*types.Actor, bool)
+
 func MakeGetActorCodeFunc(ctx context.Context, store adt.Store, child, parent *types.TipSet) (func(ctx context.Context, a address.Address) (cid.Cid, bool), error) {
-	_, span := otel.Tracer("").Start(ctx, "MakeGetActorCodeFunc")
+	getActor, err := MakeGetActorFunc(ctx, store, child, parent)
+	if err != nil {
+		return nil, err
+	}
+	return ActorCodeFunc(getActor), nil
+}
+
+// MakeGetActorFunc returns a lookup of actors in the child state tree, falling
+// back to the parent state tree for actors deleted in the transition.
+func MakeGetActorFunc(ctx context.Context, store adt.Store, child, parent *types.TipSet) (GetActorFunc, error) {
+	_, span := otel.Tracer("").Start(ctx, "MakeGetActorFunc")
 	defer span.End()
 
 	childStateTree, err := state.LoadStateTree(store, child.ParentState())
@@ -175,27 +272,65 @@ func MakeGetActorCodeFunc(ctx context.Context, store adt.Store, child, parent *t
 		return nil, fmt.Errorf("loading parent state: %w", err)
 	}
 
-	return func(ctx context.Context, a address.Address) (cid.Cid, bool) {
-		_, innerSpan := otel.Tracer("").Start(ctx, "GetActorCode")
+	return func(ctx context.Context, a address.Address) (*types.Actor, bool) {
+		_, innerSpan := otel.Tracer("").Start(ctx, "GetActor")
 		defer innerSpan.End()
 
 		act, err := childStateTree.GetActor(a)
 		if err == nil {
-			return act.Code, true
+			return act, true
 		}
 
 		// look in parent state, the address may have been deleted in the transition from parent -> child state.
 		// log.Infof("failed to find actor %s in child init actor state (err: %s), falling back to parent", a, err)
 		act, err = parentStateTree.GetActor(a)
 		if err == nil {
-			return act.Code, true
+			return act, true
 		}
 
 		// log.Infof("failed to find actor %s in parent state: %s", a, err)
-		return cid.Undef, false
+		return nil, false
 	}, nil
 }
 
+// ActorCodeFunc adapts an actor lookup into an actor code lookup.
+func ActorCodeFunc(getActor GetActorFunc) GetActorCodeFunc {
+	return func(ctx context.Context, a address.Address) (cid.Cid, bool) {
+		act, ok := getActor(ctx, a)
+		if !ok {
+			return cid.Undef, false
+		}
+		return act.Code, true
+	}
+}
+
+// CacheActorFunc memoizes a GetActor function so every address is resolved
+// against the state trees at most once.
+func CacheActorFunc(getActor GetActorFunc) GetActorFunc {
+	type entry struct {
+		act *types.Actor
+		ok  bool
+	}
+	var lk sync.Mutex
+	cache := make(map[address.Address]entry)
+
+	return func(ctx context.Context, a address.Address) (*types.Actor, bool) {
+		lk.Lock()
+		e, found := cache[a]
+		lk.Unlock()
+		if found {
+			return e.act, e.ok
+		}
+
+		act, ok := getActor(ctx, a)
+
+		lk.Lock()
+		cache[a] = entry{act: act, ok: ok}
+		lk.Unlock()
+		return act, ok
+	}
+}
+
 type marshaller func(interface{}) ([]byte, error)
 
 func MarshalWithOverrides(v interface{}, overrides map[reflect.Type]marshaller) (out []byte, err error) {
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/reward"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// maximum number of ancestors searched for the beacon entry of a block which
+// carries none
+const maxBeaconLookback = 20
+
+// EXBlockRewards explains what a block earned its miner. BlockReward is the
+// reward actor's ThisEpochReward at the block's parent state scaled by
+// WinCount over the expected number of leaders per epoch. MinerTip and
+// MinerPenalty sum the gas outcomes of the messages this block got to apply:
+// a message included by several blocks of a tipset only pays the first one.
+// They are only known once a child tipset has been built, see Executed.
+type EXBlockRewards struct {
+	WinCount        int64
+	ThisEpochReward abi.TokenAmount
+	BlockReward     abi.TokenAmount
+	Executed        bool
+	// number of messages the tips and penalties are summed over
+	AppliedMessages int
+	MinerTip        abi.TokenAmount
+	MinerPenalty    abi.TokenAmount
+	// BlockReward + MinerTip - MinerPenalty
+	NetReward abi.TokenAmount
+	// drand rounds of the block's beacon entries; a block without entries
+	// reuses the latest one of its ancestors, reported with BeaconInherited
+	BeaconRounds    []uint64
+	BeaconInherited bool `json:",omitempty"`
+}
+
+// DecodeBlockRewards computes the rewards of exblk. getActor must read the
+// block's parent state, where the reward actor holds the reward paid for the
+// block's epoch. rcpts are the receipts of the tipset containing the block,
+// see BlockMessageReceipts.
+func DecodeBlockRewards(ctx context.Context, api v0api.FullNode, exblk *EXBlock, getActor GetActorFunc, rcpts map[cid.Cid]*types.MessageReceipt) (*EXBlockRewards, error) {
+	blk := &exblk.BlockHeader
+	out := &EXBlockRewards{
+		ThisEpochReward: big.Zero(),
+		BlockReward:     big.Zero(),
+		Executed:        rcpts != nil,
+		MinerTip:        big.Zero(),
+		MinerPenalty:    big.Zero(),
+		BeaconRounds:    make([]uint64, 0, len(blk.BeaconEntries)),
+	}
+	if blk.ElectionProof != nil {
+		out.WinCount = blk.ElectionProof.WinCount
+	}
+
+	act, ok := getActor(ctx, builtin.RewardActorAddr)
+	if !ok {
+		return nil, xerrors.Errorf("reward actor not found in parent state")
+	}
+	st, err := reward.Load(apiActorStore(ctx, api), act)
+	if err != nil {
+		return nil, xerrors.Errorf("load reward actor state: %w", err)
+	}
+	if out.ThisEpochReward, err = st.ThisEpochReward(); err != nil {
+		return nil, err
+	}
+	out.BlockReward = big.Div(big.Mul(out.ThisEpochReward, big.NewInt(out.WinCount)), big.NewInt(builtin.ExpectedLeadersPerEpoch))
+
+	if out.Executed {
+		claimed, err := claimedMessages(ctx, api, blk)
+		if err != nil {
+			return nil, err
+		}
+		add := func(mcid cid.Cid, gc *GasCost) {
+			if gc == nil {
+				return
+			}
+			if _, ok := claimed[mcid]; ok {
+				return
+			}
+			claimed[mcid] = struct{}{}
+			out.AppliedMessages++
+			out.MinerTip = big.Add(out.MinerTip, gc.MinerTip)
+			out.MinerPenalty = big.Add(out.MinerPenalty, gc.MinerPenalty)
+		}
+		for _, m := range exblk.BlsMessages {
+			add(m.Cid(), m.GasCost)
+		}
+		for _, m := range exblk.SecpkMessages {
+			add(m.Cid(), m.GasCost)
+		}
+	}
+	out.NetReward = big.Sub(big.Add(out.BlockReward, out.MinerTip), out.MinerPenalty)
+
+	entries := blk.BeaconEntries
+	for cur := blk; len(entries) == 0 && cur.Height > 0; {
+		if blk.Height-cur.Height >= maxBeaconLookback {
+			return nil, xerrors.Errorf("no beacon entry within %d epochs of block", maxBeaconLookback)
+		}
+		if cur, err = api.ChainGetBlock(ctx, cur.Parents[0]); err != nil {
+			return nil, xerrors.Errorf("get parent block failed: %w", err)
+		}
+		entries = cur.BeaconEntries
+		out.BeaconInherited = true
+	}
+	for _, e := range entries {
+		out.BeaconRounds = append(out.BeaconRounds, e.Round)
+	}
+
+	return out, nil
+}
+
+// claimedMessages returns the messages of the blocks preceding blk in its
+// canonical tipset. The VM applies those first, so their miners are paid
+// for them.
+func claimedMessages(ctx context.Context, api v0api.FullNode, blk *types.BlockHeader) (map[cid.Cid]struct{}, error) {
+	ts, err := api.ChainGetTipSetByHeight(ctx, blk.Height, types.EmptyTSK)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[cid.Cid]struct{})
+	for _, b := range ts.Blocks() {
+		if b.Cid() == blk.Cid() {
+			break
+		}
+		msgs, err := api.ChainGetBlockMessages(ctx, b.Cid())
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get messages: %w", err)
+		}
+		for _, c := range msgs.Cids {
+			out[c] = struct{}{}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+)
+
+// number of miners loaded in parallel while building a role index
+const minerRoleWorkers = 32
+
+// EXMinerRole lists the roles an address holds in a miner.
+type EXMinerRole struct {
+	Miner address.Address
+	Roles []string
+}
+
+// MinerRoleIndex maps ID addresses to the miners they hold roles in.
+type MinerRoleIndex map[address.Address][]EXMinerRole
+
+// minerRoles returns the roles of every address in the miner described by
+// info. Addresses in miner info are ID addresses.
+func minerRoles(info miner.MinerInfo) map[address.Address][]string {
+	out := make(map[address.Address][]string)
+	add := func(a address.Address, role string) {
+		out[a] = append(out[a], role)
+	}
+	add(info.Owner, "owner")
+	add(info.Worker, "worker")
+	for _, c := range info.ControlAddresses {
+		add(c, "control")
+	}
+	add(info.Beneficiary, "beneficiary")
+	if info.PendingWorkerKey != nil {
+		add(info.PendingWorkerKey.NewWorker, "pending-worker")
+	}
+	if info.PendingOwnerAddress != nil {
+		add(*info.PendingOwnerAddress, "pending-owner")
+	}
+	return out
+}
+
+// BuildMinerRoleIndex loads the info of every miner at ts and indexes the
+// roles of the addresses found in it. Each miner costs a few state reads, so
+// this takes a while on mainnet.
+func BuildMinerRoleIndex(ctx context.Context, api v0api.FullNode, ts *types.TipSet) (MinerRoleIndex, error) {
+	miners, err := api.StateListMiners(ctx, ts.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	idx := make(MinerRoleIndex)
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(minerRoleWorkers)
+	for _, maddr := range miners {
+		maddr := maddr
+		eg.Go(func() error {
+			mact, err := api.StateGetActor(ectx, maddr, ts.Key())
+			if err != nil {
+				return xerrors.Errorf("get miner %s: %w", maddr, err)
+			}
+			// stores are not safe for concurrent use
+			mas, err := miner.Load(apiActorStore(ectx, api), mact)
+			if err != nil {
+				return xerrors.Errorf("load miner %s: %w", maddr, err)
+			}
+			info, err := mas.Info()
+			if err != nil {
+				return xerrors.Errorf("load miner %s info: %w", maddr, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for a, roles := range minerRoles(info) {
+				idx[a] = append(idx[a], EXMinerRole{Miner: maddr, Roles: roles})
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	for _, roles := range idx {
+		sort.Slice(roles, func(i, j int) bool {
+			a, _ := address.IDFromAddress(roles[i].Miner)
+			b, _ := address.IDFromAddress(roles[j].Miner)
+			return a < b
+		})
+	}
+	return idx, nil
+}
+
+// minerRoleIndexFile is a role index cached on disk, named after the tipset
+// it was built at by minerRoleIndexName.
+type minerRoleIndexFile struct {
+	Height abi.ChainEpoch
+	TipSet types.TipSetKey
+	Roles  map[string][]EXMinerRole
+}
+
+const minerRoleIndexPrefix = "miner-roles-"
+
+func minerRoleIndexName(ts *types.TipSet) (string, error) {
+	kcid, err := ts.Key().Cid()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d-%s.json", minerRoleIndexPrefix, ts.Height(), kcid), nil
+}
+
+var minerRoleCacheFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "cache-dir",
+		Usage: "directory of the cached miner role indexes (default: <user cache dir>/filecoin-utils)",
+	},
+	&cli.Int64Flag{
+		Name:  "max-age",
+		Usage: "when resolving at the chain head, reuse a cached index built at most this many epochs earlier",
+		Value: 120,
+	},
+	&cli.BoolFlag{
+		Name:  "refresh",
+		Usage: "rebuild the miner role index even if it is cached",
+	},
+}
+
+// LoadMinerRoleIndex returns the role index at ts from the cache in dir,
+// building and caching it when missing. With maxAge > 0 the most recent
+// index built on the chain of ts at most maxAge epochs earlier is reused
+// too. The tipset the returned index was built at is returned with it.
+func LoadMinerRoleIndex(ctx context.Context, api v0api.FullNode, ts *types.TipSet, dir string, maxAge abi.ChainEpoch, refresh bool) (MinerRoleIndex, *types.TipSet, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	if !refresh {
+		cached, err := findMinerRoleIndex(ctx, api, ts, dir, maxAge)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cached != nil {
+			idx, err := readMinerRoleIndex(filepath.Join(dir, cached.name))
+			if err != nil {
+				return nil, nil, err
+			}
+			return idx, cached.ts, nil
+		}
+	}
+
+	log.Infof("building miner role index at height %d", ts.Height())
+	idx, err := BuildMinerRoleIndex(ctx, api, ts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeMinerRoleIndex(dir, ts, idx); err != nil {
+		return nil, nil, err
+	}
+	return idx, ts, nil
+}
+
+type cachedMinerRoleIndex struct {
+	name string
+	ts   *types.TipSet
+}
+
+// findMinerRoleIndex looks for the index at ts, or failing that the most
+// recent one within maxAge epochs of ts on its chain.
+func findMinerRoleIndex(ctx context.Context, api v0api.FullNode, ts *types.TipSet, dir string, maxAge abi.ChainEpoch) (*cachedMinerRoleIndex, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *cachedMinerRoleIndex
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, minerRoleIndexPrefix) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		hs, kcid, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, minerRoleIndexPrefix), ".json"), "-")
+		if !ok {
+			continue
+		}
+		h, err := strconv.ParseInt(hs, 10, 64)
+		if err != nil {
+			continue
+		}
+		height := abi.ChainEpoch(h)
+		if height > ts.Height() || height < ts.Height()-maxAge {
+			continue
+		}
+		if best != nil && height <= best.ts.Height() {
+			continue
+		}
+
+		// only an index built on the chain of ts applies
+		hts, err := api.ChainGetTipSetByHeight(ctx, height, ts.Key())
+		if err != nil {
+			return nil, err
+		}
+		hcid, err := hts.Key().Cid()
+		if err != nil {
+			return nil, err
+		}
+		if hts.Height() != height || hcid.String() != kcid {
+			continue
+		}
+		best = &cachedMinerRoleIndex{name: name, ts: hts}
+	}
+	return best, nil
+}
+
+func readMinerRoleIndex(path string) (MinerRoleIndex, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f minerRoleIndexFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, xerrors.Errorf("parsing miner role index %s: %w", path, err)
+	}
+	idx := make(MinerRoleIndex, len(f.Roles))
+	for s, roles := range f.Roles {
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing miner role index %s: %w", path, err)
+		}
+		idx[a] = roles
+	}
+	return idx, nil
+}
+
+func writeMinerRoleIndex(dir string, ts *types.TipSet, idx MinerRoleIndex) error {
+	name, err := minerRoleIndexName(ts)
+	if err != nil {
+		return err
+	}
+	f := minerRoleIndexFile{
+		Height: ts.Height(),
+		TipSet: ts.Key(),
+		Roles:  make(map[string][]EXMinerRole, len(idx)),
+	}
+	for a, roles := range idx {
+		f.Roles[a.String()] = roles
+	}
+	b, err := json.Marshal(&f)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// minerRoleCacheDir returns the --cache-dir flag or the default cache
+// directory.
+func minerRoleCacheDir(cctx *cli.Context) (string, error) {
+	if dir := cctx.String("cache-dir"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", xerrors.Errorf("no user cache dir, pass --cache-dir: %w", err)
+	}
+	return filepath.Join(dir, "filecoin-utils"), nil
+}
+
+// loadMinerRoleIndexFlags loads the role index at ts as the cache flags of
+// cctx select. A cached index older than ts is only reused when no tipset
+// was asked for explicitly.
+func loadMinerRoleIndexFlags(ctx context.Context, cctx *cli.Context, api v0api.FullNode, ts *types.TipSet) (MinerRoleIndex, *types.TipSet, error) {
+	dir, err := minerRoleCacheDir(cctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxAge := abi.ChainEpoch(cctx.Int64("max-age"))
+	if cctx.IsSet("height") || cctx.IsSet("tipset") {
+		maxAge = 0
+	}
+	return LoadMinerRoleIndex(ctx, api, ts, dir, maxAge, cctx.Bool("refresh"))
+}
+
+// EXAddressMinerRoles lists the miners an address holds roles in, as of the
+// index tipset.
+type EXAddressMinerRoles struct {
+	Address     string
+	ID          address.Address
+	IndexHeight abi.ChainEpoch
+	IndexTipSet types.TipSetKey
+	Miners      []EXMinerRole
+}
+
+var AddrRolesCmd = &cli.Command{
+	Name:      "roles",
+	Usage:     "List the miners an address is owner, worker, control, beneficiary or pending worker/owner of",
+	ArgsUsage: "address(id/fil/eth)",
+	Description: `Scans the info of every miner and reports the roles the address holds in each.
+The scan is cached in a local index keyed by tipset, so repeat lookups are
+instant. At the chain head an index built up to --max-age epochs earlier is
+reused.`,
+	Flags: append(append([]cli.Flag{}, addrTipSetFlags...), minerRoleCacheFlags...),
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		if !cctx.Args().Present() {
+			return xerrors.Errorf("must pass the address(id/fil/eth)")
+		}
+		s := cctx.Args().First()
+		addr, _, err := parseAddress(s)
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		ts, err := loadAddrTipSet(ctx, cctx, api)
+		if err != nil {
+			return err
+		}
+		idx, its, err := loadMinerRoleIndexFlags(ctx, cctx, api, ts)
+		if err != nil {
+			return err
+		}
+
+		id, err := api.StateLookupID(ctx, addr, its.Key())
+		if err != nil {
+			return xerrors.Errorf("resolve %s: %w", addr, err)
+		}
+
+		out := EXAddressMinerRoles{
+			Address:     s,
+			ID:          id,
+			IndexHeight: its.Height(),
+			IndexTipSet: its.Key(),
+			Miners:      idx[id],
+		}
+		if out.Miners == nil {
+			out.Miners = make([]EXMinerRole, 0)
+		}
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		afmt.Println(string(b))
+		return nil
+	},
+}
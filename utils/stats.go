@@ -0,0 +1,393 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	lapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/v0api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+)
+
+// EXEpochStats are the metrics of one epoch. Messages counts the tipset's
+// messages once each, as the VM applies them, while BlockMessages counts them
+// per including block. GasUsed is only known once a child tipset has been
+// built, see Executed.
+type EXEpochStats struct {
+	Height         abi.ChainEpoch
+	NullRound      bool
+	Blocks         int
+	Miners         []address.Address `json:",omitempty"`
+	Messages       int
+	BlockMessages  int
+	GasLimit       int64
+	GasUsed        int64
+	Executed       bool
+	ParentBaseFee  *abi.TokenAmount `json:",omitempty"`
+	GasUtilization float64
+
+	methods map[string]int
+}
+
+func (s *EXEpochStats) header() []string {
+	return []string{"height", "null_round", "blocks", "miners", "messages", "block_messages", "gas_limit", "gas_used", "executed", "parent_base_fee", "gas_utilization"}
+}
+
+func (s *EXEpochStats) row() []string {
+	baseFee := ""
+	if s.ParentBaseFee != nil {
+		baseFee = s.ParentBaseFee.String()
+	}
+	return []string{
+		s.Height.String(), strconv.FormatBool(s.NullRound), strconv.Itoa(s.Blocks), strconv.Itoa(len(s.Miners)),
+		strconv.Itoa(s.Messages), strconv.Itoa(s.BlockMessages), strconv.FormatInt(s.GasLimit, 10), strconv.FormatInt(s.GasUsed, 10),
+		strconv.FormatBool(s.Executed), baseFee, strconv.FormatFloat(s.GasUtilization, 'f', 4, 64),
+	}
+}
+
+// EXChainStats aggregates the epochs of a height range. Gas figures only
+// cover executed epochs.
+type EXChainStats struct {
+	From             abi.ChainEpoch
+	To               abi.ChainEpoch
+	Epochs           int
+	NullRounds       int
+	NullRoundRate    float64
+	Blocks           int
+	BlocksPerTipSet  float64
+	DistinctMiners   int
+	Messages         int
+	BlockMessages    int
+	MessagesPerBlock float64
+	GasLimit         int64
+	GasUsed          int64
+	GasUtilization   float64
+	MinBaseFee       abi.TokenAmount
+	MaxBaseFee       abi.TokenAmount
+	MeanBaseFee      abi.TokenAmount
+	// applied messages by method name
+	Methods map[string]int
+}
+
+func (s *EXChainStats) rows() [][]string {
+	rows := [][]string{
+		{"from", s.From.String()},
+		{"to", s.To.String()},
+		{"epochs", strconv.Itoa(s.Epochs)},
+		{"null_rounds", strconv.Itoa(s.NullRounds)},
+		{"null_round_rate", strconv.FormatFloat(s.NullRoundRate, 'f', 4, 64)},
+		{"blocks", strconv.Itoa(s.Blocks)},
+		{"blocks_per_tipset", strconv.FormatFloat(s.BlocksPerTipSet, 'f', 4, 64)},
+		{"distinct_miners", strconv.Itoa(s.DistinctMiners)},
+		{"messages", strconv.Itoa(s.Messages)},
+		{"block_messages", strconv.Itoa(s.BlockMessages)},
+		{"messages_per_block", strconv.FormatFloat(s.MessagesPerBlock, 'f', 4, 64)},
+		{"gas_limit", strconv.FormatInt(s.GasLimit, 10)},
+		{"gas_used", strconv.FormatInt(s.GasUsed, 10)},
+		{"gas_utilization", strconv.FormatFloat(s.GasUtilization, 'f', 4, 64)},
+		{"min_base_fee", s.MinBaseFee.String()},
+		{"max_base_fee", s.MaxBaseFee.String()},
+		{"mean_base_fee", s.MeanBaseFee.String()},
+	}
+	names := make([]string, 0, len(s.Methods))
+	for name := range s.Methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rows = append(rows, []string{"method:" + name, strconv.Itoa(s.Methods[name])})
+	}
+	return rows
+}
+
+// tipSetStats computes the metrics of ts, whose messages are executed in
+// child. child is nil when no tipset has been built on ts yet.
+func tipSetStats(ctx context.Context, api v0api.FullNode, ts, child *types.TipSet) (*EXEpochStats, error) {
+	out := &EXEpochStats{
+		Height:        ts.Height(),
+		Blocks:        len(ts.Blocks()),
+		ParentBaseFee: &ts.Blocks()[0].ParentBaseFee,
+		Executed:      child != nil,
+		methods:       make(map[string]int),
+	}
+	seen := make(map[address.Address]struct{})
+	for _, blk := range ts.Blocks() {
+		if _, ok := seen[blk.Miner]; !ok {
+			seen[blk.Miner] = struct{}{}
+			out.Miners = append(out.Miners, blk.Miner)
+		}
+		msgs, err := api.ChainGetBlockMessages(ctx, blk.Cid())
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get messages: %w", err)
+		}
+		out.BlockMessages += len(msgs.Cids)
+	}
+
+	var (
+		msgs   []lapi.Message
+		recpts []*types.MessageReceipt
+		err    error
+	)
+	post := ts
+	if child != nil {
+		post = child
+		first := child.Blocks()[0].Cid()
+		if msgs, err = api.ChainGetParentMessages(ctx, first); err != nil {
+			return nil, xerrors.Errorf("failed to get messages: %w", err)
+		}
+		if recpts, err = api.ChainGetParentReceipts(ctx, first); err != nil {
+			return nil, xerrors.Errorf("failed to get receipts: %w", err)
+		}
+		if len(msgs) != len(recpts) {
+			return nil, xerrors.Errorf("got %d messages but %d receipts", len(msgs), len(recpts))
+		}
+	} else if msgs, err = api.ChainGetMessagesInTipset(ctx, ts.Key()); err != nil {
+		return nil, xerrors.Errorf("failed to get messages: %w", err)
+	}
+	out.Messages = len(msgs)
+
+	getCode, err := MakeGetActorCodeFunc(ctx, apiActorStore(ctx, api), post, ts)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load tipset state: %w", err)
+	}
+	for i, m := range msgs {
+		out.GasLimit += m.Message.GasLimit
+		if recpts != nil {
+			out.GasUsed += recpts[i].GasUsed
+		}
+		code, _ := getCode(ctx, m.Message.To)
+		name, _, _ := MethodAndParamsForMessage(m.Message, code)
+		if name == "" {
+			name = "Unknown"
+		}
+		out.methods[name]++
+	}
+	if out.Executed && out.GasLimit > 0 {
+		out.GasUtilization = float64(out.GasUsed) / float64(out.GasLimit)
+	}
+	return out, nil
+}
+
+// aggregateStats sums up epochs, which are in height order.
+func aggregateStats(from, to abi.ChainEpoch, epochs []*EXEpochStats) *EXChainStats {
+	out := &EXChainStats{
+		From:        from,
+		To:          to,
+		Epochs:      len(epochs),
+		MinBaseFee:  big.Zero(),
+		MaxBaseFee:  big.Zero(),
+		MeanBaseFee: big.Zero(),
+		Methods:     make(map[string]int),
+	}
+	miners := make(map[address.Address]struct{})
+	var executedLimit int64
+	baseFeeSum := big.Zero()
+	tipsets := 0
+	for _, e := range epochs {
+		if e.NullRound {
+			out.NullRounds++
+			continue
+		}
+		tipsets++
+		out.Blocks += e.Blocks
+		out.Messages += e.Messages
+		out.BlockMessages += e.BlockMessages
+		out.GasLimit += e.GasLimit
+		if e.Executed {
+			out.GasUsed += e.GasUsed
+			executedLimit += e.GasLimit
+		}
+		for _, m := range e.Miners {
+			miners[m] = struct{}{}
+		}
+		for name, n := range e.methods {
+			out.Methods[name] += n
+		}
+		baseFee := *e.ParentBaseFee
+		if tipsets == 1 || baseFee.LessThan(out.MinBaseFee) {
+			out.MinBaseFee = baseFee
+		}
+		if baseFee.GreaterThan(out.MaxBaseFee) {
+			out.MaxBaseFee = baseFee
+		}
+		baseFeeSum = big.Add(baseFeeSum, baseFee)
+	}
+	out.DistinctMiners = len(miners)
+	if out.Epochs > 0 {
+		out.NullRoundRate = float64(out.NullRounds) / float64(out.Epochs)
+	}
+	if tipsets > 0 {
+		out.BlocksPerTipSet = float64(out.Blocks) / float64(tipsets)
+		out.MeanBaseFee = big.Div(baseFeeSum, big.NewInt(int64(tipsets)))
+	}
+	if out.Blocks > 0 {
+		out.MessagesPerBlock = float64(out.BlockMessages) / float64(out.Blocks)
+	}
+	if executedLimit > 0 {
+		out.GasUtilization = float64(out.GasUsed) / float64(executedLimit)
+	}
+	return out
+}
+
+var ChainStatsCmd = &cli.Command{
+	Name:  "stats",
+	Usage: "Report per-epoch and aggregate chain metrics over a height range",
+	Description: `Reports for every epoch in the range its blocks, miners, messages, gas used
+against gas limit and base fee, and aggregates them together with the null
+round rate and the applied messages by method name.
+
+A tipset's gas used is read from the receipts carried by the next non-null
+tipset, so the tipset at the chain head is reported without it.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:     "from",
+			Usage:    "first height of the range",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:  "to",
+			Usage: "last height of the range (default: chain head)",
+			Value: -1,
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format: json or csv",
+			Value: "json",
+		},
+		&cli.BoolFlag{
+			Name:  "summary",
+			Usage: "only print the aggregate metrics",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of epochs processed in parallel",
+			Value: 8,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		afmt := NewAppFmt(cctx.App)
+
+		api, closer, err := GetFullNodeAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+
+		format := cctx.String("format")
+		if format != "json" && format != "csv" {
+			return xerrors.Errorf("unknown format %q", format)
+		}
+		concurrency := cctx.Int("concurrency")
+		if concurrency < 1 {
+			concurrency = 1
+		}
+
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return err
+		}
+		from := abi.ChainEpoch(cctx.Int64("from"))
+		to := head.Height()
+		if cctx.Int64("to") >= 0 && abi.ChainEpoch(cctx.Int64("to")) < to {
+			to = abi.ChainEpoch(cctx.Int64("to"))
+		}
+		if from > to {
+			return xerrors.Errorf("--from %d is after --to %d", from, to)
+		}
+
+		// resolve the range first, every tipset's child is the next one
+		tipsets := make([]*types.TipSet, 0, to-from+1)
+		for h := from; h <= to; h++ {
+			ts, err := api.ChainGetTipSetByHeight(ctx, h, head.Key())
+			if err != nil {
+				return xerrors.Errorf("get tipset at height %d: %w", h, err)
+			}
+			if ts.Height() != h {
+				ts = nil
+			}
+			tipsets = append(tipsets, ts)
+		}
+		var last *types.TipSet
+		for i := len(tipsets) - 1; i >= 0 && last == nil; i-- {
+			last = tipsets[i]
+		}
+		var after *types.TipSet
+		if last != nil {
+			if after, err = nextTipSet(ctx, api, last); err != nil {
+				return err
+			}
+		}
+
+		epochs := make([]*EXEpochStats, len(tipsets))
+		var eg errgroup.Group
+		eg.SetLimit(concurrency)
+		child := after
+		for i := len(tipsets) - 1; i >= 0; i-- {
+			i, ts, next := i, tipsets[i], child
+			if ts == nil {
+				epochs[i] = &EXEpochStats{Height: from + abi.ChainEpoch(i), NullRound: true}
+				continue
+			}
+			child = ts
+			eg.Go(func() error {
+				s, err := tipSetStats(ctx, api, ts, next)
+				if err != nil {
+					return xerrors.Errorf("stats of height %d: %w", ts.Height(), err)
+				}
+				epochs[i] = s
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+		summary := aggregateStats(from, to, epochs)
+
+		if format == "csv" {
+			w := csv.NewWriter(cctx.App.Writer)
+			if cctx.Bool("summary") {
+				if err := w.Write([]string{"metric", "value"}); err != nil {
+					return err
+				}
+				if err := w.WriteAll(summary.rows()); err != nil {
+					return err
+				}
+			} else {
+				if err := w.Write((*EXEpochStats)(nil).header()); err != nil {
+					return err
+				}
+				for _, e := range epochs {
+					if err := w.Write(e.row()); err != nil {
+						return err
+					}
+				}
+			}
+			w.Flush()
+			return w.Error()
+		}
+
+		var v interface{} = summary
+		if !cctx.Bool("summary") {
+			v = struct {
+				Summary *EXChainStats
+				Epochs  []*EXEpochStats
+			}{summary, epochs}
+		}
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		afmt.Println(string(b))
+		return nil
+	},
+}